@@ -0,0 +1,149 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/bhojpur/logger/pkg/engine/alils"
+)
+
+var alilsCmdOpts struct {
+	Project   string
+	Endpoint  string
+	KeyID     string
+	KeySecret string
+	LogStore  string
+}
+
+var alilsCmd = &cobra.Command{
+	Use:   "alils",
+	Short: "Works with the logs in Alibaba Cloud Log Service",
+}
+
+var alilsTailCmdOpts struct {
+	From       string
+	Checkpoint string
+	JSON       bool
+}
+
+var alilsTailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Streams the logs of a logstore, from every shard",
+	Example: `  logger alils tail --project shop --endpoint cn-hangzhou.log.aliyuncs.com --logstore app --from 2021-03-04T10:00:00Z
+  logger alils tail --logstore app --checkpoint ~/.logger/app.json --json`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if alilsCmdOpts.LogStore == "" {
+			log.Fatal("--logstore is required")
+		}
+		from, err := alilsFrom(alilsTailCmdOpts.From)
+		if err != nil {
+			log.WithError(err).Fatal("invalid --from")
+		}
+		project, err := alils.NewLogProject(alilsCmdOpts.Project, alilsCmdOpts.Endpoint, alilsCmdOpts.KeyID, alilsCmdOpts.KeySecret)
+		if err != nil {
+			log.WithError(err).Fatal("cannot set up the project")
+		}
+		store, err := project.GetLogStore(alilsCmdOpts.LogStore)
+		if err != nil {
+			log.WithError(err).Fatal("cannot get the logstore")
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		consumer := alils.NewConsumer(store, from, alilsTailCmdOpts.Checkpoint)
+		enc := json.NewEncoder(os.Stdout)
+		err = consumer.Run(ctx, func(shardID int, lg *alils.LogGroup) {
+			for _, l := range lg.GetLogs() {
+				when := time.Unix(int64(l.GetTime()), 0)
+				if alilsTailCmdOpts.JSON {
+					contents := make(map[string]string, len(l.GetContents()))
+					for _, c := range l.GetContents() {
+						contents[c.GetKey()] = c.GetValue()
+					}
+					enc.Encode(map[string]interface{}{
+						"time":     when.UTC().Format(time.RFC3339),
+						"shard":    shardID,
+						"topic":    lg.GetTopic(),
+						"source":   lg.GetSource(),
+						"contents": contents,
+					})
+					continue
+				}
+				var b strings.Builder
+				fmt.Fprintf(&b, "%s [%s] %s", when.Format("2006-01-02 15:04:05"), lg.GetTopic(), lg.GetSource())
+				for _, c := range l.GetContents() {
+					fmt.Fprintf(&b, " %s=%s", c.GetKey(), strconv.Quote(c.GetValue()))
+				}
+				fmt.Println(b.String())
+			}
+		})
+		if err != nil {
+			log.WithError(err).Fatal("tail failed")
+		}
+	},
+}
+
+// alilsFrom returns the cursor position for from: begin, end, a unix
+// timestamp, an RFC 3339 time or a duration back from now.
+func alilsFrom(from string) (string, error) {
+	switch from {
+	case "begin", "end":
+		return from, nil
+	}
+	if _, err := strconv.ParseInt(from, 10, 64); err == nil {
+		return from, nil
+	}
+	if t, err := time.Parse(time.RFC3339, from); err == nil {
+		return strconv.FormatInt(t.Unix(), 10), nil
+	}
+	d, err := time.ParseDuration(from)
+	if err != nil {
+		return "", fmt.Errorf("%q is neither begin, end, a unix timestamp, an RFC 3339 time nor a duration", from)
+	}
+	return strconv.FormatInt(time.Now().Add(-d).Unix(), 10), nil
+}
+
+func init() {
+	rootCmd.AddCommand(alilsCmd)
+	alilsCmd.AddCommand(alilsTailCmd)
+
+	alilsCmd.PersistentFlags().StringVar(&alilsCmdOpts.Project, "project", os.Getenv("ALILS_PROJECT"), "project name (defaults to ALILS_PROJECT env var)")
+	alilsCmd.PersistentFlags().StringVar(&alilsCmdOpts.Endpoint, "endpoint", os.Getenv("ALILS_ENDPOINT"), "Log Service endpoint, like cn-hangzhou.log.aliyuncs.com (defaults to ALILS_ENDPOINT env var)")
+	alilsCmd.PersistentFlags().StringVar(&alilsCmdOpts.KeyID, "key-id", os.Getenv("ALILS_KEY_ID"), "access key id (defaults to ALILS_KEY_ID env var)")
+	alilsCmd.PersistentFlags().StringVar(&alilsCmdOpts.KeySecret, "key-secret", os.Getenv("ALILS_KEY_SECRET"), "access key secret (defaults to ALILS_KEY_SECRET env var)")
+	alilsCmd.PersistentFlags().StringVar(&alilsCmdOpts.LogStore, "logstore", "", "logstore name")
+
+	alilsTailCmd.Flags().StringVar(&alilsTailCmdOpts.From, "from", "end", "where to start: begin, end, a unix timestamp, an RFC 3339 time or a duration back from now")
+	alilsTailCmd.Flags().StringVar(&alilsTailCmdOpts.Checkpoint, "checkpoint", "", "file keeping the shard cursors, to go on where the last tail stopped")
+	alilsTailCmd.Flags().BoolVar(&alilsTailCmdOpts.JSON, "json", false, "print the logs as JSON, one per line")
+}
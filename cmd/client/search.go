@@ -0,0 +1,119 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/bhojpur/logger/pkg/engine"
+	"github.com/bhojpur/logger/pkg/engine/es"
+)
+
+var searchCmdOpts struct {
+	ES       string
+	APIKey   string
+	Index    string
+	Size     int
+	Follow   bool
+	Interval time.Duration
+	JSON     bool
+	NoColor  bool
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search [query]",
+	Short: "Searches the logs indexed in Elasticsearch by the es adapter",
+	Long: `Searches the logs indexed in Elasticsearch by the es adapter.
+
+The query is made of space separated terms:
+
+  text, "quoted phrase"   words or phrases of the message
+  level>=error            levels by name, with =, <, <=, > or >=
+  prefix=api              the prefix, like msg, file, line, hostname and pid
+  order=7                 a structured field
+  since=1h, until=30m     a time range, as a duration back from now or an RFC 3339 time
+
+Without since the last 24 hours are searched.`,
+	Example: `  logger search --es http://localhost:9200/ 'level>=error prefix=api since=2h timeout'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if searchCmdOpts.ES == "" {
+			log.Fatal("--es is required")
+		}
+		q, err := es.ParseQuery(strings.Join(args, " "), time.Now())
+		if err != nil {
+			log.WithError(err).Fatal("invalid query")
+		}
+		searcher, err := es.NewSearcher(searchCmdOpts.ES, searchCmdOpts.APIKey, searchCmdOpts.Index)
+		if err != nil {
+			log.WithError(err).Fatal("cannot connect to Elasticsearch")
+		}
+
+		console := engine.NewConsole()
+		if searchCmdOpts.NoColor {
+			console.Init(`{"color":false}`)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		show := func(h es.Hit) {
+			if searchCmdOpts.JSON {
+				enc.Encode(h.Doc)
+				return
+			}
+			console.WriteMsg(h.LogMsg())
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		hits, err := searcher.Search(ctx, q, searchCmdOpts.Size)
+		if err != nil {
+			log.WithError(err).Fatal("search failed")
+		}
+		for _, h := range hits {
+			show(h)
+		}
+		if !searchCmdOpts.Follow {
+			return
+		}
+		if err = searcher.Follow(ctx, q, hits, searchCmdOpts.Interval, show); err != nil {
+			log.WithError(err).Fatal("follow failed")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().StringVar(&searchCmdOpts.ES, "es", os.Getenv("LOGGER_ES"), "Elasticsearch url, with basic auth credentials if needed (defaults to LOGGER_ES env var)")
+	searchCmd.Flags().StringVar(&searchCmdOpts.APIKey, "api-key", os.Getenv("LOGGER_ES_API_KEY"), "Elasticsearch API key (defaults to LOGGER_ES_API_KEY env var)")
+	searchCmd.Flags().StringVar(&searchCmdOpts.Index, "index", "{2006.01.02}", "index pattern as in the es adapter config, the default matches the default index naming")
+	searchCmd.Flags().IntVarP(&searchCmdOpts.Size, "size", "n", 100, "number of documents to print, the newest")
+	searchCmd.Flags().BoolVarP(&searchCmdOpts.Follow, "follow", "f", false, "keep printing new documents")
+	searchCmd.Flags().DurationVar(&searchCmdOpts.Interval, "interval", 2*time.Second, "poll interval of --follow")
+	searchCmd.Flags().BoolVar(&searchCmdOpts.JSON, "json", false, "print the documents as JSON, one per line")
+	searchCmd.Flags().BoolVar(&searchCmdOpts.NoColor, "no-color", false, "print without colours")
+}
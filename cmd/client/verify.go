@@ -0,0 +1,82 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/bhojpur/logger/pkg/engine"
+)
+
+var verifyCmdOpts struct {
+	Keys     []string
+	KeysFile string
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Verifies the hash chain of a tamper-evident log file and of the files it was rotated to",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		keys := make(map[string]string)
+		if verifyCmdOpts.KeysFile != "" {
+			body, err := ioutil.ReadFile(verifyCmdOpts.KeysFile)
+			if err != nil {
+				log.WithError(err).Fatal("cannot read keys file")
+			}
+			if err = json.Unmarshal(body, &keys); err != nil {
+				log.WithError(err).Fatal("cannot parse keys file")
+			}
+		}
+		for _, k := range verifyCmdOpts.Keys {
+			segs := strings.SplitN(k, "=", 2)
+			if len(segs) != 2 {
+				log.Fatalf("invalid key %q, expected <keyid>=<hex key>", k)
+			}
+			keys[segs[0]] = segs[1]
+		}
+		decoded, err := engine.ParseChainKeys(keys)
+		if err != nil {
+			log.WithError(err).Fatal("invalid key")
+		}
+
+		report, err := engine.VerifyChain(args[0], decoded)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("OK: %d entries (seq %d to %d) in %d file(s)\n", report.Entries, report.FirstSeq, report.LastSeq, len(report.Files))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringArrayVar(&verifyCmdOpts.Keys, "key", nil, "chain key as <keyid>=<hex key>, may be repeated")
+	verifyCmd.Flags().StringVar(&verifyCmdOpts.KeysFile, "keys-file", "", "json file holding the chain keys by key id, like the chainkeys option of the file adapter")
+}
@@ -23,8 +23,13 @@ package alils
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/pkg/errors"
@@ -35,8 +40,13 @@ import (
 const (
 	// CacheSize sets the flush size
 	CacheSize int = 64
-	// Delimiter defines the topic delimiter
+	// Delimiter defines the topic delimiter of the "legacy" topic_from
 	Delimiter string = "##"
+
+	// maxPutLogs is the most logs SLS accepts in one PutLogs request.
+	maxPutLogs = 4096
+	// maxRetryBackoff caps the doubling wait between retries.
+	maxRetryBackoff = 30 * time.Second
 )
 
 // Config is the Config for Ali Log
@@ -51,6 +61,34 @@ type Config struct {
 	Level     int      `json:"level"`
 	FlushWhen int      `json:"flush_when"`
 	Formatter string   `json:"formatter"`
+
+	// TopicFrom picks the topic of a message: "prefix" takes the logger
+	// prefix, "field" the TopicField structured field and "legacy" the
+	// word before Delimiter in the message, which is the default when
+	// Topics is set. Unless Topics lists the allowed topics, every new
+	// topic gets its own LogGroup until it has nothing left to send; the
+	// others go to the empty topic. "legacy" only uses Topics.
+	TopicFrom  string `json:"topic_from"`
+	TopicField string `json:"topic_field"`
+	// Tags become the LogTags of every LogGroup, such as host or env.
+	Tags map[string]string `json:"tags"`
+
+	// FlushInterval sends the buffered logs every so many milliseconds,
+	// zero or a negative value leaves it to FlushWhen, Flush and Destroy.
+	FlushInterval int `json:"flush_interval"`
+	// Retries is how often a failed PutLogs is tried again, waiting
+	// RetryBackoff milliseconds first and twice as long each time after.
+	Retries      int `json:"retries"`
+	RetryBackoff int `json:"retry_backoff"`
+	// MaxBuffer bounds the logs held across all topics. Past it, the
+	// DropPolicy "oldest" discards the oldest logs and "newest" the
+	// incoming ones.
+	MaxBuffer  int    `json:"max_buffer"`
+	DropPolicy string `json:"drop_policy"`
+
+	UseHTTP       bool   `json:"use_http"`
+	Timeout       int    `json:"timeout"` // milliseconds, per request
+	SecurityToken string `json:"security_token"`
 }
 
 // aliLSWriter implements LoggerInterface.
@@ -58,9 +96,18 @@ type Config struct {
 type aliLSWriter struct {
 	store    *LogStore
 	group    []*LogGroup
-	withMap  bool
 	groupMap map[string]*LogGroup
+	tags     []*LogTag
 	lock     *sync.Mutex
+	buffered int
+	dropped  int
+
+	// sendLock keeps the flushes from interleaving.
+	sendLock sync.Mutex
+	kick     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
 	Config
 	formatter logs.LogFormatter
 }
@@ -69,26 +116,58 @@ type aliLSWriter struct {
 func NewAliLS() logs.Logger {
 	alils := new(aliLSWriter)
 	alils.Level = logs.LevelTrace
+	alils.FlushWhen = CacheSize
+	alils.FlushInterval = 3000
+	alils.Retries = 3
+	alils.RetryBackoff = 500
+	alils.MaxBuffer = 10000
+	alils.DropPolicy = "oldest"
+	alils.TopicField = "topic"
 	alils.formatter = alils
 	return alils
 }
 
-// Init parses config and initializes struct
+// Init parses config and initializes struct. Calling it again sends
+// what is buffered and stops the flusher of the last config first.
 func (c *aliLSWriter) Init(config string) error {
+	c.Destroy()
+	c.once = sync.Once{}
+	c.group, c.tags = nil, nil
+	c.buffered = 0
+
 	err := json.Unmarshal([]byte(config), c)
 	if err != nil {
 		return err
 	}
 
-	if c.FlushWhen > CacheSize {
+	if c.FlushWhen > CacheSize || c.FlushWhen <= 0 {
 		c.FlushWhen = CacheSize
 	}
+	if c.DropPolicy != "oldest" && c.DropPolicy != "newest" {
+		return errors.New(fmt.Sprintf("unknown drop_policy: %s", c.DropPolicy))
+	}
+	if c.TopicFrom == "" && len(c.Topics) > 0 {
+		c.TopicFrom = "legacy"
+	} else if c.TopicFrom == "" {
+		c.TopicFrom = "prefix"
+	}
+	if c.TopicFrom != "prefix" && c.TopicFrom != "field" && c.TopicFrom != "legacy" {
+		return errors.New(fmt.Sprintf("unknown topic_from: %s", c.TopicFrom))
+	}
 
 	prj := &LogProject{
 		Name:            c.Project,
 		Endpoint:        c.Endpoint,
 		AccessKeyID:     c.KeyID,
 		AccessKeySecret: c.KeySecret,
+		SecurityToken:   c.SecurityToken,
+		UseHTTP:         c.UseHTTP,
+	}
+	if c.Timeout > 0 {
+		prj.HTTPClient = &http.Client{
+			Timeout:   time.Duration(c.Timeout) * time.Millisecond,
+			Transport: defaultClient.Transport,
+		}
 	}
 
 	store, err := prj.GetLogStore(c.LogStore)
@@ -98,33 +177,26 @@ func (c *aliLSWriter) Init(config string) error {
 
 	c.store = store
 
+	keys := make([]string, 0, len(c.Tags))
+	for k := range c.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c.tags = append(c.tags, &LogTag{Key: proto.String(k), Value: proto.String(c.Tags[k])})
+	}
+
 	// Create default Log Group
-	c.group = append(c.group, &LogGroup{
-		Topic:  proto.String(""),
-		Source: proto.String(c.Source),
-		Logs:   make([]*Log, 0, c.FlushWhen),
-	})
+	c.group = append(c.group, c.newGroup(""))
 
 	// Create other Log Group
 	c.groupMap = make(map[string]*LogGroup)
 	for _, topic := range c.Topics {
-
-		lg := &LogGroup{
-			Topic:  proto.String(topic),
-			Source: proto.String(c.Source),
-			Logs:   make([]*Log, 0, c.FlushWhen),
-		}
-
+		lg := c.newGroup(topic)
 		c.group = append(c.group, lg)
 		c.groupMap[topic] = lg
 	}
 
-	if len(c.group) == 1 {
-		c.withMap = false
-	} else {
-		c.withMap = true
-	}
-
 	c.lock = &sync.Mutex{}
 
 	if len(c.Formatter) > 0 {
@@ -135,92 +207,286 @@ func (c *aliLSWriter) Init(config string) error {
 		c.formatter = fmtr
 	}
 
+	c.kick = make(chan struct{}, 1)
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.run(time.Duration(c.FlushInterval) * time.Millisecond)
+
 	return nil
 }
 
+// Format returns the message alone, as level, file and line have their
+// own keys, except for the "legacy" topic_from.
 func (c *aliLSWriter) Format(lm *logs.LogMsg) string {
-	return lm.OldStyleFormat()
+	if c.TopicFrom == "legacy" {
+		return lm.OldStyleFormat()
+	}
+	return lm.Message()
 }
 
 func (c *aliLSWriter) SetFormatter(f logs.LogFormatter) {
 	c.formatter = f
 }
 
-// WriteMsg writes a message in connection.
-// If connection is down, try to re-connect.
+// WriteMsg buffers a message in the LogGroup of its topic.
 func (c *aliLSWriter) WriteMsg(lm *logs.LogMsg) error {
 	if lm.Level > c.Level {
 		return nil
 	}
 
-	var topic string
-	var content string
-	var lg *LogGroup
-	if c.withMap {
-
-		// Topic，LogGroup
-		strs := strings.SplitN(lm.Msg, Delimiter, 2)
-		if len(strs) == 2 {
-			pos := strings.LastIndex(strs[0], " ")
-			topic = strs[0][pos+1 : len(strs[0])]
-			lg = c.groupMap[topic]
-		}
-
-		// send to empty Topic
-		if lg == nil {
-			lg = c.group[0]
-		}
-	} else {
-		lg = c.group[0]
-	}
-
-	content = c.formatter.Format(lm)
-
-	c1 := &LogContent{
-		Key:   proto.String("msg"),
-		Value: proto.String(content),
-	}
-
 	l := &Log{
-		Time: proto.Uint32(uint32(lm.When.Unix())),
-		Contents: []*LogContent{
-			c1,
-		},
+		Time:     proto.Uint32(uint32(lm.When.Unix())),
+		Contents: c.contents(lm),
 	}
 
 	c.lock.Lock()
+	if c.MaxBuffer > 0 && c.buffered >= c.MaxBuffer && c.DropPolicy == "newest" {
+		c.dropped++
+		c.lock.Unlock()
+		return nil
+	}
+	lg := c.logGroup(c.topic(lm))
 	lg.Logs = append(lg.Logs, l)
+	c.buffered++
+	c.trim()
+	full := len(lg.Logs) >= c.FlushWhen
 	c.lock.Unlock()
 
-	if len(lg.Logs) >= c.FlushWhen {
-		c.flush(lg)
+	if full {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
 	}
 	return nil
 }
 
-// Flush implementing method. empty.
+// Flush sends the logs of every topic.
 func (c *aliLSWriter) Flush() {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
 
-	// flush all group
-	for _, lg := range c.group {
+	c.lock.Lock()
+	groups := append([]*LogGroup(nil), c.group...)
+	c.lock.Unlock()
+	for _, lg := range groups {
 		c.flush(lg)
 	}
+
+	c.lock.Lock()
+	c.dropIdleGroups()
+	c.lock.Unlock()
+}
+
+// dropIdleGroups forgets the topics added by logGroup that have nothing
+// left to send, so that a topic_field with many values does not keep a
+// LogGroup for each. Callers hold c.lock.
+func (c *aliLSWriter) dropIdleGroups() {
+	if len(c.Topics) > 0 {
+		return
+	}
+	groups := c.group[:1]
+	for _, lg := range c.group[1:] {
+		if len(lg.Logs) > 0 {
+			groups = append(groups, lg)
+		} else {
+			delete(c.groupMap, lg.GetTopic())
+		}
+	}
+	for i := len(groups); i < len(c.group); i++ {
+		c.group[i] = nil
+	}
+	c.group = groups
 }
 
-// Destroy destroy connection writer and close tcp listener.
+// Destroy stops the interval flusher and sends what is left.
 func (c *aliLSWriter) Destroy() {
+	if c.stop == nil {
+		return
+	}
+	c.once.Do(func() {
+		close(c.stop)
+		<-c.done
+		c.Flush()
+	})
 }
 
-func (c *aliLSWriter) flush(lg *LogGroup) {
+// topic returns the topic of lm, as TopicFrom says.
+func (c *aliLSWriter) topic(lm *logs.LogMsg) string {
+	switch c.TopicFrom {
+	case "prefix":
+		return lm.Prefix
+	case "field":
+		if v, ok := lm.Fields[c.TopicField]; ok {
+			return fmt.Sprint(v)
+		}
+	case "legacy":
+		if strs := strings.SplitN(lm.Message(), Delimiter, 2); len(strs) == 2 {
+			return strs[0][strings.LastIndex(strs[0], " ")+1:]
+		}
+	}
+	return ""
+}
 
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	err := c.store.PutLogs(lg)
-	if err != nil {
-		return
+// logGroup returns the LogGroup of topic, adding it unless Topics is set
+// or TopicFrom is "legacy". Callers hold c.lock.
+func (c *aliLSWriter) logGroup(topic string) *LogGroup {
+	if topic == "" {
+		return c.group[0]
+	}
+	if lg, ok := c.groupMap[topic]; ok {
+		return lg
 	}
+	if len(c.Topics) > 0 || c.TopicFrom == "legacy" {
+		return c.group[0]
+	}
+	lg := c.newGroup(topic)
+	c.group = append(c.group, lg)
+	c.groupMap[topic] = lg
+	return lg
+}
+
+func (c *aliLSWriter) newGroup(topic string) *LogGroup {
+	return &LogGroup{
+		Topic:   proto.String(topic),
+		Source:  proto.String(c.Source),
+		LogTags: c.tags,
+		Logs:    make([]*Log, 0, c.FlushWhen),
+	}
+}
 
+// contents returns the formatted message as "msg", followed by level,
+// prefix, file, line and the structured fields by name. Fields named like
+// one of the keys before them are left out. The "legacy" topic_from only
+// sends "msg".
+func (c *aliLSWriter) contents(lm *logs.LogMsg) []*LogContent {
+	cs := []*LogContent{{Key: proto.String("msg"), Value: proto.String(c.formatter.Format(lm))}}
+	if c.TopicFrom == "legacy" {
+		return cs
+	}
+
+	add := func(k, v string) {
+		cs = append(cs, &LogContent{Key: proto.String(k), Value: proto.String(v)})
+	}
+	add("level", logs.LevelName(lm.Level))
+	if lm.Prefix != "" {
+		add("prefix", lm.Prefix)
+	}
+	if lm.FilePath != "" {
+		add("file", lm.FilePath)
+		add("line", strconv.Itoa(lm.LineNumber))
+	}
+
+	keys := make([]string, 0, len(lm.Fields))
+	for k := range lm.Fields {
+		switch k {
+		case "msg", "level", "prefix", "file", "line":
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, fmt.Sprint(lm.Fields[k]))
+	}
+	return cs
+}
+
+// run flushes on every tick of interval and whenever a group fills up,
+// until Destroy.
+func (c *aliLSWriter) run(interval time.Duration) {
+	defer close(c.done)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.kick:
+		case <-tick:
+		}
+		c.Flush()
+	}
+}
+
+// flush sends the logs of lg in requests of at most maxPutLogs. The logs
+// that could not be sent go back to the front of lg.
+func (c *aliLSWriter) flush(lg *LogGroup) {
+	c.lock.Lock()
+	pending := lg.Logs
 	lg.Logs = make([]*Log, 0, c.FlushWhen)
+	c.buffered -= len(pending)
+	c.lock.Unlock()
+
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > maxPutLogs {
+			n = maxPutLogs
+		}
+		err := c.put(&LogGroup{
+			Topic:   lg.Topic,
+			Source:  lg.Source,
+			LogTags: lg.LogTags,
+			Logs:    pending[:n],
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "AliLS: failed to put %d logs to %s: %v\n", len(pending), c.LogStore, err)
+			c.lock.Lock()
+			lg.Logs = append(pending, lg.Logs...)
+			c.buffered += len(pending)
+			c.trim()
+			c.lock.Unlock()
+			return
+		}
+		pending = pending[n:]
+	}
+}
+
+// put sends lg, retrying with a doubling backoff. Once Destroy has been
+// called it no longer waits between tries.
+func (c *aliLSWriter) put(lg *LogGroup) error {
+	wait := time.Duration(c.RetryBackoff) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := c.store.PutLogs(lg)
+		if err == nil || attempt >= c.Retries {
+			return err
+		}
+		select {
+		case <-c.stop:
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > maxRetryBackoff {
+			wait = maxRetryBackoff
+		}
+	}
+}
+
+// trim drops logs from the largest group until the buffer fits MaxBuffer,
+// the oldest or the newest ones as DropPolicy says. Callers hold c.lock.
+func (c *aliLSWriter) trim() {
+	if c.MaxBuffer <= 0 {
+		return
+	}
+	for c.buffered > c.MaxBuffer {
+		lg := c.group[0]
+		for _, g := range c.group[1:] {
+			if len(g.Logs) > len(lg.Logs) {
+				lg = g
+			}
+		}
+		if c.DropPolicy == "newest" {
+			lg.Logs = lg.Logs[:len(lg.Logs)-1]
+		} else {
+			lg.Logs = lg.Logs[1:]
+		}
+		c.buffered--
+		c.dropped++
+	}
 }
 
 func init() {
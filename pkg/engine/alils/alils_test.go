@@ -0,0 +1,391 @@
+package alils
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	lz4 "github.com/cloudflare/golz4"
+
+	logs "github.com/bhojpur/logger/pkg/engine"
+)
+
+// fakeSLS answers GetLogStore and PutLogs, failing the first fails puts.
+type fakeSLS struct {
+	lock    sync.Mutex
+	fails   int
+	puts    int
+	groups  []*LogGroup
+	headers http.Header
+}
+
+func (f *fakeSLS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.headers = r.Header.Clone()
+	if r.Method == "GET" {
+		fmt.Fprint(w, `{"logstoreName":"app","ttl":1,"shardCount":1}`)
+		return
+	}
+	f.puts++
+	if f.fails != 0 {
+		f.fails--
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"errorCode":"InternalServerError","errorMessage":"try again"}`)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	size, _ := strconv.Atoi(r.Header.Get("x-sls-bodyrawsize"))
+	raw := make([]byte, size)
+	if err := lz4.Uncompress(body, raw); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	lg := &LogGroup{}
+	if err := proto.Unmarshal(raw, lg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.groups = append(f.groups, lg)
+}
+
+func (f *fakeSLS) msgs() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var msgs []string
+	for _, lg := range f.groups {
+		for _, l := range lg.Logs {
+			msgs = append(msgs, l.Contents[0].GetValue())
+		}
+	}
+	return msgs
+}
+
+// newFakeSLS serves a fakeSLS, with every project host name leading to it.
+func newFakeSLS(t *testing.T, fails int) *fakeSLS {
+	f := &fakeSLS{fails: fails}
+	srv := httptest.NewServer(f)
+	transport := defaultClient.Transport
+	defaultClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}
+	t.Cleanup(func() {
+		defaultClient.Transport = transport
+		srv.Close()
+	})
+	return f
+}
+
+func newTestWriter(t *testing.T, config map[string]interface{}) *aliLSWriter {
+	config["project"] = "proj"
+	config["endpoint"] = "sls.test"
+	config["log_store"] = "app"
+	config["use_http"] = true
+	b, _ := json.Marshal(config)
+	w := NewAliLS().(*aliLSWriter)
+	assert.Nil(t, w.Init(string(b)))
+	t.Cleanup(w.Destroy)
+	return w
+}
+
+func writeTestMsgs(w *aliLSWriter, msgs ...string) {
+	for _, msg := range msgs {
+		w.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: msg, When: time.Now()})
+	}
+}
+
+func TestAliLSWriterFlushInterval(t *testing.T) {
+	f := newFakeSLS(t, 0)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": 10,
+		"security_token": "sts",
+	})
+
+	writeTestMsgs(w, "one", "two")
+	assert.Eventually(t, func() bool { return len(f.msgs()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.True(t, strings.HasSuffix(f.msgs()[1], "two"))
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	assert.Equal(t, "sts", f.headers.Get("x-acs-security-token"))
+}
+
+func TestAliLSWriterFlushWhen(t *testing.T) {
+	f := newFakeSLS(t, 0)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"flush_when":     2,
+	})
+
+	writeTestMsgs(w, "one")
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, f.msgs())
+
+	writeTestMsgs(w, "two")
+	assert.Eventually(t, func() bool { return len(f.msgs()) == 2 }, time.Second, 5*time.Millisecond)
+}
+
+func TestAliLSWriterRetry(t *testing.T) {
+	f := newFakeSLS(t, 2)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"retries":        2,
+		"retry_backoff":  1,
+	})
+
+	writeTestMsgs(w, "one")
+	w.Flush()
+	assert.Equal(t, 3, f.puts)
+	assert.Len(t, f.msgs(), 1)
+}
+
+func TestAliLSWriterRetryOnDestroy(t *testing.T) {
+	f := newFakeSLS(t, 2)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"retries":        2,
+		"retry_backoff":  60000,
+	})
+
+	// Destroy still retries, without the backoff
+	writeTestMsgs(w, "one")
+	start := time.Now()
+	w.Destroy()
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.Equal(t, 3, f.puts)
+	assert.Len(t, f.msgs(), 1)
+}
+
+func TestAliLSWriterInitTwice(t *testing.T) {
+	f := newFakeSLS(t, 0)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": 10,
+		"topics":         []string{"pay"},
+		"tags":           map[string]string{"env": "test"},
+	})
+	writeTestMsgs(w, "one")
+	done := w.done
+
+	// the second Init sends the buffered logs and stops the first flusher
+	b, _ := json.Marshal(map[string]interface{}{
+		"project": "proj", "endpoint": "sls.test", "log_store": "app", "use_http": true,
+		"flush_interval": -1, "topics": []string{"pay"}, "tags": map[string]string{"env": "test"},
+	})
+	assert.Nil(t, w.Init(string(b)))
+	select {
+	case <-done:
+	default:
+		t.Error("the first flusher is still running")
+	}
+	assert.Len(t, f.msgs(), 1)
+	assert.Len(t, w.group, 2)
+	assert.Len(t, w.tags, 1)
+
+	writeTestMsgs(w, "two")
+	w.Destroy()
+	assert.Len(t, f.msgs(), 2)
+}
+
+func TestAliLSWriterMaxBuffer(t *testing.T) {
+	f := newFakeSLS(t, 1)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"retries":        0,
+		"max_buffer":     2,
+	})
+
+	writeTestMsgs(w, "one", "two", "three")
+	assert.Equal(t, 1, w.dropped)
+
+	// the failed put keeps the logs for the next flush
+	w.Flush()
+	assert.Empty(t, f.msgs())
+	assert.Equal(t, 2, w.buffered)
+
+	w.Flush()
+	msgs := f.msgs()
+	if assert.Len(t, msgs, 2) {
+		assert.True(t, strings.HasSuffix(msgs[0], "two"))
+		assert.True(t, strings.HasSuffix(msgs[1], "three"))
+	}
+}
+
+func TestAliLSWriterDropNewest(t *testing.T) {
+	f := newFakeSLS(t, 0)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"max_buffer":     2,
+		"drop_policy":    "newest",
+	})
+
+	writeTestMsgs(w, "one", "two", "three")
+	w.Destroy()
+	msgs := f.msgs()
+	if assert.Len(t, msgs, 2) {
+		assert.True(t, strings.HasSuffix(msgs[0], "one"))
+		assert.True(t, strings.HasSuffix(msgs[1], "two"))
+	}
+	assert.Equal(t, 1, w.dropped)
+}
+
+func (f *fakeSLS) topics() map[string][]*Log {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	topics := map[string][]*Log{}
+	for _, lg := range f.groups {
+		topics[lg.GetTopic()] = append(topics[lg.GetTopic()], lg.Logs...)
+	}
+	return topics
+}
+
+func logContents(l *Log) [][2]string {
+	var cs [][2]string
+	for _, c := range l.Contents {
+		cs = append(cs, [2]string{c.GetKey(), c.GetValue()})
+	}
+	return cs
+}
+
+func TestAliLSWriterContents(t *testing.T) {
+	f := newFakeSLS(t, 0)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"tags":           map[string]string{"host": "web-1", "env": "prod"},
+	})
+
+	w.WriteMsg(&logs.LogMsg{Level: logs.LevelWarn, Msg: "disk %d%%", Args: []interface{}{91}, Prefix: "api",
+		FilePath: "main.go", LineNumber: 12, When: time.Now(),
+		Fields: map[string]interface{}{"mount": "/var", "free": 9, "level": "shadowed"}})
+	w.WriteMsg(&logs.LogMsg{Level: logs.LevelInfo, Msg: "up", When: time.Now()})
+	w.Flush()
+
+	topics := f.topics()
+	if assert.Len(t, topics["api"], 1) && assert.Len(t, topics[""], 1) {
+		assert.Equal(t, [][2]string{{"msg", "disk 91%"}, {"level", "warning"}, {"prefix", "api"},
+			{"file", "main.go"}, {"line", "12"}, {"free", "9"}, {"mount", "/var"}}, logContents(topics["api"][0]))
+		assert.Equal(t, [][2]string{{"msg", "up"}, {"level", "info"}}, logContents(topics[""][0]))
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	tags := f.groups[0].GetLogTags()
+	if assert.Len(t, tags, 2) {
+		assert.Equal(t, "env", tags[0].GetKey())
+		assert.Equal(t, "prod", tags[0].GetValue())
+		assert.Equal(t, "host", tags[1].GetKey())
+	}
+}
+
+func TestAliLSWriterTopicField(t *testing.T) {
+	f := newFakeSLS(t, 0)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"topic_from":     "field",
+		"topics":         []string{"audit"},
+	})
+
+	for _, topic := range []string{"audit", "other", "audit"} {
+		w.WriteMsg(&logs.LogMsg{Level: logs.LevelInfo, Msg: topic, When: time.Now(),
+			Fields: map[string]interface{}{"topic": topic}})
+	}
+	w.WriteMsg(&logs.LogMsg{Level: logs.LevelInfo, Msg: "none", When: time.Now()})
+	w.Flush()
+
+	topics := f.topics()
+	assert.Len(t, topics["audit"], 2)
+	assert.Len(t, topics[""], 2)
+	assert.Len(t, topics, 2)
+}
+
+func TestAliLSWriterLegacyTopics(t *testing.T) {
+	f := newFakeSLS(t, 0)
+	// legacy is the default with topics
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"topics":         []string{"pay"},
+	})
+	assert.Equal(t, "legacy", w.TopicFrom)
+
+	writeTestMsgs(w, "order pay##charged", "no topic", "order ship##sent")
+	// the topic is read from the message with its arguments
+	w.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: "%s##refunded", Args: []interface{}{"pay"}, When: time.Now()})
+	w.Flush()
+
+	topics := f.topics()
+	if assert.Len(t, topics["pay"], 2) && assert.Len(t, topics[""], 2) {
+		assert.Equal(t, [][2]string{{"msg", "[E]  order pay##charged"}}, logContents(topics["pay"][0]))
+		assert.Equal(t, [][2]string{{"msg", "[E]  pay##refunded"}}, logContents(topics["pay"][1]))
+	}
+
+	// without topics, everything goes to the empty topic
+	f = newFakeSLS(t, 0)
+	w = newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"topic_from":     "legacy",
+	})
+	writeTestMsgs(w, "order pay##charged")
+	w.Flush()
+	assert.Len(t, f.topics()[""], 1)
+	assert.Len(t, w.group, 1)
+}
+
+func TestAliLSWriterIdleTopics(t *testing.T) {
+	f := newFakeSLS(t, 1)
+	w := newTestWriter(t, map[string]interface{}{
+		"flush_interval": -1,
+		"retries":        0,
+	})
+
+	for _, prefix := range []string{"api", "db", "api"} {
+		w.WriteMsg(&logs.LogMsg{Level: logs.LevelInfo, Msg: "m", Prefix: prefix, When: time.Now()})
+	}
+	// the put of one topic fails, so it keeps its LogGroup
+	w.Flush()
+	assert.Len(t, w.group, 2)
+	w.Flush()
+	assert.Len(t, w.group, 1)
+	assert.Len(t, w.groupMap, 0)
+
+	topics := f.topics()
+	assert.Len(t, topics["api"], 2)
+	assert.Len(t, topics["db"], 1)
+}
+
+func TestAliLSWriterConfigErrors(t *testing.T) {
+	assert.NotNil(t, NewAliLS().Init(`{"topic_from":"message"}`))
+	assert.NotNil(t, NewAliLS().Init(`{"drop_policy":"random"}`))
+}
@@ -0,0 +1,268 @@
+package alils
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// shardReader is the part of LogStore a Consumer reads with.
+type shardReader interface {
+	Shards() ([]*Shard, error)
+	GetCursor(shardID int, from string) (string, error)
+	GetLogs(shardID int, cursor string, logGroupMaxCount int) (*LogGroupList, string, error)
+}
+
+// Consumer reads every shard of a logstore concurrently. Shards created
+// by a split or a merge while it runs are read from their beginning, once
+// the readonly shards they came from are read to their end, so that the
+// logs of a key stay in order. A readonly shard is left once read to its
+// end.
+type Consumer struct {
+	// From is where shards without a checkpoint are read from: "begin",
+	// "end" or a unix timestamp in seconds.
+	From string
+	// CheckpointFile keeps the cursor of every shard, so that a new
+	// Consumer goes on where the last one stopped. Empty for none.
+	CheckpointFile string
+	// MaxGroups is the number of log groups read at a time.
+	MaxGroups int
+	// PollInterval is the wait when a shard has no new logs.
+	PollInterval time.Duration
+	// ShardInterval is the interval at which the shard list is read.
+	ShardInterval time.Duration
+
+	store shardReader
+	name  string // project/logstore, the prefix of the checkpoint keys
+
+	lock        sync.Mutex
+	handle      sync.Mutex // fn is called by one goroutine at a time
+	checkpoints map[string]string
+	status      map[int]string
+	running     map[int]bool
+}
+
+// NewConsumer creates a Consumer of store reading from from, "begin",
+// "end" or a unix timestamp in seconds.
+func NewConsumer(store *LogStore, from, checkpointFile string) *Consumer {
+	name := store.Name
+	if store.project != nil {
+		name = store.project.Name + "/" + name
+	}
+	return &Consumer{
+		From:           from,
+		CheckpointFile: checkpointFile,
+		MaxGroups:      100,
+		PollInterval:   time.Second,
+		ShardInterval:  30 * time.Second,
+		store:          store,
+		name:           name,
+	}
+}
+
+// Run calls fn with every log group read, with the shard it was read
+// from, until ctx is done or reading fails for good. Errors reading a
+// shard are retried; the first error listing the shards is returned.
+func (c *Consumer) Run(ctx context.Context, fn func(shardID int, lg *LogGroup)) error {
+	c.checkpoints = map[string]string{}
+	c.status = map[int]string{}
+	c.running = map[int]bool{}
+	if err := c.load(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	from, first := c.From, true
+	froms := map[int]string{} // where each shard is read from, as first seen
+	for {
+		shards, err := c.store.Shards()
+		if err != nil && first {
+			return err
+		}
+		first = false
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "alils: listing shards: %s\n", err)
+		}
+		for _, sh := range shards {
+			if _, ok := froms[sh.ShardID]; !ok {
+				froms[sh.ShardID] = from
+			}
+			c.lock.Lock()
+			c.status[sh.ShardID] = sh.Status
+			start := !c.running[sh.ShardID] && c.checkpoints[c.key(sh.ShardID)] != shardDone &&
+				!c.held(sh, shards)
+			if start {
+				c.running[sh.ShardID] = true
+			}
+			c.lock.Unlock()
+			if start {
+				wg.Add(1)
+				go func(id int, from string) {
+					defer wg.Done()
+					c.consume(ctx, id, from, fn)
+				}(sh.ShardID, froms[sh.ShardID])
+			}
+		}
+		// shards showing up later come from splits and merges
+		from = "begin"
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.ShardInterval):
+		}
+	}
+}
+
+// shardDone is the checkpoint of a readonly shard read to its end.
+const shardDone = "done"
+
+// held reports whether sh waits for a shard it was split or merged from:
+// an older readonly shard over the same keys, not yet read to its end.
+// Callers hold c.lock.
+func (c *Consumer) held(sh *Shard, shards []*Shard) bool {
+	for _, p := range shards {
+		if p.ShardID == sh.ShardID || p.Status != "readonly" || p.CreateTime >= sh.CreateTime {
+			continue
+		}
+		if p.InclusiveBeginKey < sh.ExclusiveEndKey && sh.InclusiveBeginKey < p.ExclusiveEndKey &&
+			c.checkpoints[c.key(p.ShardID)] != shardDone {
+			return true
+		}
+	}
+	return false
+}
+
+// consume reads one shard until ctx is done, or to its end when it is
+// readonly.
+func (c *Consumer) consume(ctx context.Context, id int, from string, fn func(int, *LogGroup)) {
+	defer func() {
+		c.lock.Lock()
+		delete(c.running, id)
+		c.lock.Unlock()
+	}()
+
+	c.lock.Lock()
+	cursor := c.checkpoints[c.key(id)]
+	c.lock.Unlock()
+	for attempt := 0; ; attempt++ {
+		var err error
+		if cursor == "" {
+			cursor, err = c.store.GetCursor(id, from)
+		} else {
+			var gl *LogGroupList
+			var next string
+			gl, next, err = c.store.GetLogs(id, cursor, c.MaxGroups)
+			if err == nil {
+				attempt = -1
+				c.handle.Lock()
+				for _, lg := range gl.GetLogGroups() {
+					fn(id, lg)
+				}
+				c.handle.Unlock()
+
+				if next == cursor || next == "" {
+					c.lock.Lock()
+					readonly := c.status[id] == "readonly"
+					c.lock.Unlock()
+					if readonly {
+						c.save(id, shardDone)
+						return
+					}
+					if !c.wait(ctx, c.PollInterval) {
+						return
+					}
+					continue
+				}
+				cursor = next
+				c.save(id, cursor)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "alils: reading shard %d: %s\n", id, err)
+			delay := c.PollInterval << uint(attempt)
+			if max := 30 * time.Second; delay > max || delay <= 0 {
+				delay = max
+			}
+			if !c.wait(ctx, delay) {
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (c *Consumer) wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (c *Consumer) key(id int) string {
+	return fmt.Sprintf("%s/%d", c.name, id)
+}
+
+// load reads the checkpoint file, if any.
+func (c *Consumer) load() error {
+	if c.CheckpointFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.CheckpointFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.checkpoints)
+}
+
+// save records the cursor of a shard and writes the checkpoint file.
+func (c *Consumer) save(id int, cursor string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checkpoints[c.key(id)] = cursor
+	if c.CheckpointFile == "" {
+		return
+	}
+	data, _ := json.MarshalIndent(c.checkpoints, "", "  ")
+	tmp := c.CheckpointFile + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, c.CheckpointFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "alils: writing checkpoint %s: %s\n", filepath.Base(c.CheckpointFile), err)
+	}
+}
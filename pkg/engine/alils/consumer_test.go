@@ -0,0 +1,213 @@
+package alils
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeShards holds the log groups of every shard, read one at a time.
+// Cursors are positions in the list of a shard.
+type fakeShards struct {
+	lock   sync.Mutex
+	shards []*Shard
+	groups map[int][]*LogGroup
+	froms  map[int]string
+}
+
+func (f *fakeShards) Shards() ([]*Shard, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*Shard(nil), f.shards...), nil
+}
+
+func (f *fakeShards) GetCursor(shardID int, from string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.froms[shardID] = from
+	if from == "end" {
+		return strconv.Itoa(len(f.groups[shardID])), nil
+	}
+	return "0", nil
+}
+
+func (f *fakeShards) GetLogs(shardID int, cursor string, max int) (*LogGroupList, string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	pos, err := strconv.Atoi(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	gl := &LogGroupList{}
+	if pos < len(f.groups[shardID]) {
+		gl.LogGroups = f.groups[shardID][pos : pos+1]
+		pos++
+	}
+	return gl, strconv.Itoa(pos), nil
+}
+
+func (f *fakeShards) add(shardID int, topic string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.groups[shardID] = append(f.groups[shardID], &LogGroup{Topic: proto.String(topic)})
+}
+
+type groupRecorder struct {
+	lock   sync.Mutex
+	topics []string
+}
+
+func (r *groupRecorder) record(shardID int, lg *LogGroup) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.topics = append(r.topics, fmt.Sprintf("%d:%s", shardID, lg.GetTopic()))
+}
+
+func (r *groupRecorder) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.topics)
+}
+
+func TestConsumer(t *testing.T) {
+	f := &fakeShards{
+		shards: []*Shard{{ShardID: 0, Status: "readwrite"}, {ShardID: 1, Status: "readonly"}},
+		groups: map[int][]*LogGroup{},
+		froms:  map[int]string{},
+	}
+	f.add(0, "a")
+	f.add(1, "b")
+	f.add(1, "c")
+	checkpoints := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	c := &Consumer{From: "begin", CheckpointFile: checkpoints, MaxGroups: 10, PollInterval: 5 * time.Millisecond,
+		ShardInterval: 20 * time.Millisecond, store: f, name: "proj/app"}
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &groupRecorder{}
+	done := make(chan error)
+	go func() { done <- c.Run(ctx, rec.record) }()
+
+	assert.Eventually(t, func() bool { return rec.count() == 3 }, time.Second, 5*time.Millisecond)
+	// shard 0 splits into 2 and 3, which are read from their beginning
+	f.lock.Lock()
+	f.shards[0].Status = "readonly"
+	f.shards = append(f.shards, &Shard{ShardID: 2, Status: "readwrite"}, &Shard{ShardID: 3, Status: "readwrite"})
+	f.lock.Unlock()
+	f.add(2, "d")
+	f.add(3, "e")
+	want := map[string]string{"proj/app/0": "done", "proj/app/1": "done", "proj/app/2": "1", "proj/app/3": "1"}
+	var saved map[string]string
+	assert.Eventually(t, func() bool {
+		data, err := ioutil.ReadFile(checkpoints)
+		saved = nil
+		return err == nil && json.Unmarshal(data, &saved) == nil && assert.ObjectsAreEqual(want, saved)
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+
+	assert.ElementsMatch(t, []string{"0:a", "1:b", "1:c", "2:d", "3:e"}, rec.topics)
+	assert.Equal(t, "begin", f.froms[2])
+	assert.Equal(t, want, saved)
+
+	// a new consumer goes on from the checkpoints
+	f.add(2, "f")
+	c = &Consumer{From: "end", CheckpointFile: checkpoints, MaxGroups: 10, PollInterval: 5 * time.Millisecond,
+		ShardInterval: time.Second, store: f, name: "proj/app"}
+	ctx, cancel = context.WithCancel(context.Background())
+	rec = &groupRecorder{}
+	go func() { done <- c.Run(ctx, rec.record) }()
+	assert.Eventually(t, func() bool { return rec.count() == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, []string{"2:f"}, rec.topics)
+}
+
+func TestConsumerSplitOrder(t *testing.T) {
+	f := &fakeShards{
+		shards: []*Shard{
+			{ShardID: 0, Status: "readonly", InclusiveBeginKey: "00", ExclusiveEndKey: "80", CreateTime: 1},
+			{ShardID: 1, Status: "readwrite", InclusiveBeginKey: "80", ExclusiveEndKey: "ff", CreateTime: 1},
+			{ShardID: 2, Status: "readwrite", InclusiveBeginKey: "00", ExclusiveEndKey: "40", CreateTime: 2},
+		},
+		groups: map[int][]*LogGroup{},
+		froms:  map[int]string{},
+	}
+	f.add(0, "a")
+	f.add(0, "b")
+	f.add(0, "c")
+	f.add(1, "x")
+	f.add(2, "d")
+
+	c := &Consumer{From: "begin", MaxGroups: 10, PollInterval: 5 * time.Millisecond,
+		ShardInterval: 20 * time.Millisecond, store: f, name: "proj/app"}
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &groupRecorder{}
+	done := make(chan error)
+	go func() { done <- c.Run(ctx, rec.record) }()
+	assert.Eventually(t, func() bool { return rec.count() == 5 }, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+
+	// shard 2, split from 0, is read once 0 is read to its end
+	var split []string
+	for _, topic := range rec.topics {
+		if topic[0] != '1' {
+			split = append(split, topic)
+		}
+	}
+	assert.Equal(t, []string{"0:a", "0:b", "0:c", "2:d"}, split)
+}
+
+func TestNewConsumer(t *testing.T) {
+	c := NewConsumer(&LogStore{Name: "app", project: &LogProject{Name: "proj"}}, "end", "")
+	assert.Equal(t, "proj/app/3", c.key(3))
+}
+
+func TestConsumerFromEnd(t *testing.T) {
+	f := &fakeShards{
+		shards: []*Shard{{ShardID: 0, Status: "readwrite"}},
+		groups: map[int][]*LogGroup{},
+		froms:  map[int]string{},
+	}
+	f.add(0, "old")
+	c := &Consumer{From: "end", MaxGroups: 10, PollInterval: 5 * time.Millisecond, ShardInterval: time.Second, store: f, name: "proj/app"}
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &groupRecorder{}
+	done := make(chan error)
+	go func() { done <- c.Run(ctx, rec.record) }()
+	time.Sleep(20 * time.Millisecond)
+	f.add(0, "new")
+	assert.Eventually(t, func() bool { return rec.count() == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, []string{"0:new"}, rec.topics)
+}
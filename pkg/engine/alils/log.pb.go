@@ -105,13 +105,46 @@ func (m *LogContent) GetValue() string {
 	return ""
 }
 
+// LogTag defines a key and value tagging all the logs of a LogGroup
+type LogTag struct {
+	Key             *string `protobuf:"bytes,1,req,name=Key" json:"Key,omitempty"`
+	Value           *string `protobuf:"bytes,2,req,name=Value" json:"Value,omitempty"`
+	XXXUnrecognized []byte  `json:"-"`
+}
+
+// Reset LogTag
+func (m *LogTag) Reset() { *m = LogTag{} }
+
+// String returns the compact text
+func (m *LogTag) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage not implemented
+func (*LogTag) ProtoMessage() {}
+
+// GetKey returns the key
+func (m *LogTag) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+// GetValue returns the value
+func (m *LogTag) GetValue() string {
+	if m != nil && m.Value != nil {
+		return *m.Value
+	}
+	return ""
+}
+
 // LogGroup defines the logs struct
 type LogGroup struct {
-	Logs            []*Log  `protobuf:"bytes,1,rep,name=Logs" json:"Logs,omitempty"`
-	Reserved        *string `protobuf:"bytes,2,opt,name=Reserved" json:"Reserved,omitempty"`
-	Topic           *string `protobuf:"bytes,3,opt,name=Topic" json:"Topic,omitempty"`
-	Source          *string `protobuf:"bytes,4,opt,name=Source" json:"Source,omitempty"`
-	XXXUnrecognized []byte  `json:"-"`
+	Logs            []*Log    `protobuf:"bytes,1,rep,name=Logs" json:"Logs,omitempty"`
+	Reserved        *string   `protobuf:"bytes,2,opt,name=Reserved" json:"Reserved,omitempty"`
+	Topic           *string   `protobuf:"bytes,3,opt,name=Topic" json:"Topic,omitempty"`
+	Source          *string   `protobuf:"bytes,4,opt,name=Source" json:"Source,omitempty"`
+	LogTags         []*LogTag `protobuf:"bytes,6,rep,name=LogTags" json:"LogTags,omitempty"`
+	XXXUnrecognized []byte    `json:"-"`
 }
 
 // Reset LogGroup
@@ -158,6 +191,14 @@ func (m *LogGroup) GetSource() string {
 	return ""
 }
 
+// GetLogTags returns the tags of the loggroup
+func (m *LogGroup) GetLogTags() []*LogTag {
+	if m != nil {
+		return m.LogTags
+	}
+	return nil
+}
+
 // LogGroupList defines the LogGroups
 type LogGroupList struct {
 	LogGroups       []*LogGroup `protobuf:"bytes,1,rep,name=logGroups" json:"logGroups,omitempty"`
@@ -260,6 +301,44 @@ func (m *LogContent) MarshalTo(data []byte) (int, error) {
 	return i, nil
 }
 
+// Marshal LogTag
+func (m *LogTag) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// MarshalTo logtag to data
+func (m *LogTag) MarshalTo(data []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Key == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("Key")
+	}
+	data[i] = 0xa
+	i++
+	i = encodeVarintLog(data, i, uint64(len(*m.Key)))
+	i += copy(data[i:], *m.Key)
+
+	if m.Value == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("Value")
+	}
+	data[i] = 0x12
+	i++
+	i = encodeVarintLog(data, i, uint64(len(*m.Value)))
+	i += copy(data[i:], *m.Value)
+	if m.XXXUnrecognized != nil {
+		i += copy(data[i:], m.XXXUnrecognized)
+	}
+	return i, nil
+}
+
 // Marshal LogGroup
 func (m *LogGroup) Marshal() (data []byte, err error) {
 	size := m.Size()
@@ -307,6 +386,18 @@ func (m *LogGroup) MarshalTo(data []byte) (int, error) {
 		i = encodeVarintLog(data, i, uint64(len(*m.Source)))
 		i += copy(data[i:], *m.Source)
 	}
+	if len(m.LogTags) > 0 {
+		for _, msg := range m.LogTags {
+			data[i] = 0x32
+			i++
+			i = encodeVarintLog(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
 	if m.XXXUnrecognized != nil {
 		i += copy(data[i:], m.XXXUnrecognized)
 	}
@@ -413,6 +504,24 @@ func (m *LogContent) Size() (n int) {
 	return n
 }
 
+// Size returns LogTag size
+func (m *LogTag) Size() (n int) {
+	var l int
+	_ = l
+	if m.Key != nil {
+		l = len(*m.Key)
+		n += 1 + l + sovLog(uint64(l))
+	}
+	if m.Value != nil {
+		l = len(*m.Value)
+		n += 1 + l + sovLog(uint64(l))
+	}
+	if m.XXXUnrecognized != nil {
+		n += len(m.XXXUnrecognized)
+	}
+	return n
+}
+
 // Size returns LogGroup size based on Logs
 func (m *LogGroup) Size() (n int) {
 	var l int
@@ -435,6 +544,12 @@ func (m *LogGroup) Size() (n int) {
 		l = len(*m.Source)
 		n += 1 + l + sovLog(uint64(l))
 	}
+	if len(m.LogTags) > 0 {
+		for _, e := range m.LogTags {
+			l = e.Size()
+			n += 1 + l + sovLog(uint64(l))
+		}
+	}
 	if m.XXXUnrecognized != nil {
 		n += len(m.XXXUnrecognized)
 	}
@@ -702,6 +817,128 @@ func (m *LogContent) Unmarshal(data []byte) error {
 	return nil
 }
 
+// Unmarshal unmarshals data to LogTag
+func (m *LogTag) Unmarshal(data []byte) error {
+	var hasFields [1]uint64
+	l := len(data)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowLog
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LogTag: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LogTag: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowLog
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthLog
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(data[iNdEx:postIndex])
+			m.Key = &s
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowLog
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthLog
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(data[iNdEx:postIndex])
+			m.Value = &s
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000002)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipLog(data[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthLog
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXXUnrecognized = append(m.XXXUnrecognized, data[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("Key")
+	}
+	if hasFields[0]&uint64(0x00000002) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("Value")
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
 // Unmarshal unmarshals data to LogGroup
 func (m *LogGroup) Unmarshal(data []byte) error {
 	l := len(data)
@@ -853,6 +1090,37 @@ func (m *LogGroup) Unmarshal(data []byte) error {
 			s := string(data[iNdEx:postIndex])
 			m.Source = &s
 			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LogTags", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowLog
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthLog
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.LogTags = append(m.LogTags, &LogTag{})
+			if err := m.LogTags[len(m.LogTags)-1].Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipLog(data[iNdEx:])
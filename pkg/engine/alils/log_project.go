@@ -47,6 +47,9 @@ type LogProject struct {
 	Endpoint        string // IP or hostname of SLS endpoint
 	AccessKeyID     string
 	AccessKeySecret string
+	SecurityToken   string       // STS token, with temporary access keys
+	UseHTTP         bool         // plain http instead of https
+	HTTPClient      *http.Client // nil for a client with a 30s timeout
 }
 
 // NewLogProject creates a new SLS project.
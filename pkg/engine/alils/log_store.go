@@ -46,11 +46,27 @@ type LogStore struct {
 
 // Shard defines the Log Shard
 type Shard struct {
-	ShardID int `json:"shardID"`
+	ShardID           int    `json:"shardID"`
+	Status            string `json:"status"` // readwrite, or readonly once split or merged
+	InclusiveBeginKey string `json:"inclusiveBeginKey"`
+	ExclusiveEndKey   string `json:"exclusiveEndKey"`
+	CreateTime        int64  `json:"createTime"`
 }
 
 // ListShards returns shard id list of this logstore.
 func (s *LogStore) ListShards() (shardIDs []int, err error) {
+	shards, err := s.Shards()
+	if err != nil {
+		return
+	}
+	for _, v := range shards {
+		shardIDs = append(shardIDs, v.ShardID)
+	}
+	return
+}
+
+// Shards returns the shards of this logstore.
+func (s *LogStore) Shards() (shards []*Shard, err error) {
 	h := map[string]string{
 		"x-sls-bodyrawsize": "0",
 	}
@@ -79,15 +95,7 @@ func (s *LogStore) ListShards() (shardIDs []int, err error) {
 		return
 	}
 
-	var shards []*Shard
 	err = json.Unmarshal(buf, &shards)
-	if err != nil {
-		return
-	}
-
-	for _, v := range shards {
-		shardIDs = append(shardIDs, v.ShardID)
-	}
 	return
 }
 
@@ -117,6 +125,7 @@ func (s *LogStore) PutLogs(lg *LogGroup) (err error) {
 	if err != nil {
 		return
 	}
+	defer r.Body.Close()
 
 	buf, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -246,7 +255,7 @@ func (s *LogStore) GetLogsBytes(shardID int, cursor string,
 		return
 	}
 	bodyRawSize, err := strconv.Atoi(v[0])
-	if err != nil {
+	if err != nil || bodyRawSize == 0 {
 		return
 	}
 
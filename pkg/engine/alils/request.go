@@ -25,8 +25,12 @@ import (
 	"crypto/md5"
 	"fmt"
 	"net/http"
+	"time"
 )
 
+// defaultClient sends the requests of the projects without an HTTPClient.
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
 // request sends a request to SLS.
 func request(project *LogProject, method, uri string, headers map[string]string,
 	body []byte) (resp *http.Response, err error) {
@@ -42,6 +46,9 @@ func request(project *LogProject, method, uri string, headers map[string]string,
 	headers["Date"] = nowRFC1123()
 	headers["x-sls-apiversion"] = version
 	headers["x-sls-signaturemethod"] = signatureMethod
+	if project.SecurityToken != "" {
+		headers["x-acs-security-token"] = project.SecurityToken
+	}
 	if body != nil {
 		bodyMD5 := fmt.Sprintf("%X", md5.Sum(body))
 		headers["Content-MD5"] = bodyMD5
@@ -63,7 +70,11 @@ func request(project *LogProject, method, uri string, headers map[string]string,
 
 	// Initialize http request
 	reader := bytes.NewReader(body)
-	urlStr := fmt.Sprintf("http://%v.%v%v", project.Name, project.Endpoint, uri)
+	scheme := "https"
+	if project.UseHTTP {
+		scheme = "http"
+	}
+	urlStr := fmt.Sprintf("%v://%v.%v%v", scheme, project.Name, project.Endpoint, uri)
 	req, err := http.NewRequest(method, urlStr, reader)
 	if err != nil {
 		return
@@ -73,7 +84,11 @@ func request(project *LogProject, method, uri string, headers map[string]string,
 	}
 
 	// Get ready to do request
-	resp, err = http.DefaultClient.Do(req)
+	client := project.HTTPClient
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err = client.Do(req)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,374 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BatchOptions holds the batching settings of the adapters that send
+// messages in groups. Durations are in milliseconds.
+type BatchOptions struct {
+	BatchSize  int `json:"batchSize"`  // messages per batch
+	BatchBytes int `json:"batchBytes"` // formatted bytes per batch, 0 for no limit
+	BatchWait  int `json:"batchWait"`  // longest time a message waits for its batch
+	QueueSize  int `json:"queueSize"`  // messages waiting to be sent before new ones are dropped
+	Retries    int `json:"retries"`
+	RetryMin   int `json:"retryMin"`
+	RetryMax   int `json:"retryMax"`
+}
+
+func defaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		BatchSize: 100,
+		BatchWait: 1000,
+		QueueSize: 10000,
+		Retries:   5,
+		RetryMin:  500,
+		RetryMax:  30000,
+	}
+}
+
+// BatchEntry is a message waiting in a Batcher.
+type BatchEntry struct {
+	Msg  LogMsg // a copy, the logger reuses its messages
+	Text string // the message formatted by the adapter
+	Key  string // where the adapter sends the entry, like an index name
+
+	attempt int // sends that failed
+}
+
+// retryError marks a failed send worth retrying, after the given delay
+// when the remote end asked for one.
+type retryError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+// RetryEntries is returned by a send function when only some entries of
+// the batch failed for a reason worth retrying. The Batcher queues them
+// again ahead of the others and waits the backoff before sending more.
+type RetryEntries struct {
+	Entries []BatchEntry
+	Err     error
+}
+
+func (e *RetryEntries) Error() string {
+	return e.Err.Error()
+}
+
+// BatchStats holds the counters of a Batcher.
+type BatchStats struct {
+	Retried uint64 // entries queued again by RetryEntries
+	Failed  uint64 // entries given up after the retries
+	Dropped uint64 // entries dropped because the queue was full
+	Queued  int    // entries waiting to be sent
+}
+
+// Batcher groups messages and hands them to send from one goroutine,
+// retrying failed batches with backoff. Adapters outside this package
+// batch through it as well.
+type Batcher struct {
+	name string
+	opts BatchOptions
+	send func(entries []BatchEntry) error
+
+	lock    sync.Mutex
+	entries []BatchEntry
+	bytes   int
+	stats   BatchStats
+
+	kick  chan struct{}
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewBatcher starts a Batcher handing the batches to send. name prefixes
+// the errors printed when messages are given up.
+func NewBatcher(name string, opts BatchOptions, send func([]BatchEntry) error) *Batcher {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.QueueSize < opts.BatchSize {
+		opts.QueueSize = opts.BatchSize
+	}
+	if opts.BatchWait <= 0 {
+		opts.BatchWait = defaultBatchOptions().BatchWait
+	}
+	b := &Batcher{
+		name:  name,
+		opts:  opts,
+		send:  send,
+		kick:  make(chan struct{}, 1),
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues lm with its formatted text, dropping it when the queue is
+// full.
+func (b *Batcher) Add(lm *LogMsg, text string) {
+	b.AddKey(lm, "", text)
+}
+
+// AddKey queues lm like Add, with the key the send function reads.
+func (b *Batcher) AddKey(lm *LogMsg, key, text string) {
+	b.lock.Lock()
+	if len(b.entries) >= b.opts.QueueSize {
+		b.stats.Dropped++
+		b.lock.Unlock()
+		return
+	}
+	b.entries = append(b.entries, BatchEntry{Msg: *lm, Text: text, Key: key})
+	b.bytes += len(text)
+	full := b.full()
+	b.lock.Unlock()
+	if full {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *Batcher) full() bool {
+	return len(b.entries) >= b.opts.BatchSize || b.opts.BatchBytes > 0 && b.bytes >= b.opts.BatchBytes
+}
+
+// take removes the next batch from the queue.
+func (b *Batcher) take() []BatchEntry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n, size := 0, 0
+	for n < len(b.entries) && n < b.opts.BatchSize {
+		next := len(b.entries[n].Text)
+		if n > 0 && b.opts.BatchBytes > 0 && size+next > b.opts.BatchBytes {
+			break
+		}
+		size += next
+		n++
+	}
+	batch := make([]BatchEntry, n)
+	copy(batch, b.entries)
+	b.entries = append(b.entries[:0], b.entries[n:]...)
+	b.bytes -= size
+	return batch
+}
+
+// requeue puts the entries of a RetryEntries back at the front of the
+// queue, giving up those out of retries. It returns the highest attempt
+// of the queued ones, or -1 when there are none.
+func (b *Batcher) requeue(re *RetryEntries) int {
+	var again []BatchEntry
+	attempt := -1
+	for _, e := range re.Entries {
+		if e.attempt >= b.opts.Retries {
+			b.giveUp(1, re.Err)
+			continue
+		}
+		e.attempt++
+		if e.attempt > attempt {
+			attempt = e.attempt
+		}
+		again = append(again, e)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.stats.Retried += uint64(len(again))
+	for _, e := range again {
+		b.bytes += len(e.Text)
+	}
+	b.entries = append(again, b.entries...)
+	return attempt
+}
+
+func (b *Batcher) giveUp(n int, err error) {
+	b.lock.Lock()
+	b.stats.Failed += uint64(n)
+	b.lock.Unlock()
+	fmt.Fprintf(os.Stderr, "%s: dropping %d messages: %s\n", b.name, n, err)
+}
+
+func (b *Batcher) pending() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.entries)
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	wait := time.Duration(b.opts.BatchWait) * time.Millisecond
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-b.kick:
+			for b.ready() {
+				b.deliver(b.take())
+			}
+		case <-timer.C:
+			b.deliver(b.take())
+		case reply := <-b.flush:
+			b.drain()
+			close(reply)
+		case <-b.stop:
+			b.drain()
+			return
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+func (b *Batcher) ready() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.full()
+}
+
+// drain sends everything queued.
+func (b *Batcher) drain() {
+	for b.pending() > 0 {
+		b.deliver(b.take())
+	}
+}
+
+// deliver sends batch, retrying while the error is a retryError or a
+// network error. RetryEntries are queued again instead. Retries stop
+// waiting when the Batcher is closed.
+func (b *Batcher) deliver(batch []BatchEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		err := b.send(batch)
+		if err == nil {
+			return
+		}
+		var delay time.Duration
+		var re *retryError
+		var ne net.Error
+		var partial *RetryEntries
+		switch {
+		case errors.As(err, &partial):
+			if attempt = b.requeue(partial); attempt > 0 {
+				b.wait(backoffDelay(attempt-1,
+					time.Duration(b.opts.RetryMin)*time.Millisecond,
+					time.Duration(b.opts.RetryMax)*time.Millisecond))
+			}
+			return
+		case errors.As(err, &re):
+			delay = re.after
+		case errors.As(err, &ne):
+		default:
+			attempt = b.opts.Retries
+		}
+		if attempt >= b.opts.Retries {
+			b.giveUp(len(batch), err)
+			return
+		}
+		if delay == 0 {
+			delay = backoffDelay(attempt,
+				time.Duration(b.opts.RetryMin)*time.Millisecond,
+				time.Duration(b.opts.RetryMax)*time.Millisecond)
+		}
+		if !b.wait(delay) {
+			// closing: one last attempt without waiting
+			attempt = b.opts.Retries - 1
+		}
+	}
+}
+
+// wait sleeps for delay, or until the Batcher is closed, which it
+// reports by returning false.
+func (b *Batcher) wait(delay time.Duration) bool {
+	select {
+	case <-time.After(delay):
+		return true
+	case <-b.stop:
+		return false
+	}
+}
+
+// Flush blocks until every queued message has been sent or given up.
+func (b *Batcher) Flush() {
+	reply := make(chan struct{})
+	select {
+	case b.flush <- reply:
+		<-reply
+	case <-b.done:
+	}
+}
+
+// Close sends what is queued and stops the Batcher.
+func (b *Batcher) Close() {
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	<-b.done
+}
+
+// Stats returns the counters of the Batcher.
+func (b *Batcher) Stats() BatchStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	s := b.stats
+	s.Queued = len(b.entries)
+	return s
+}
+
+// backoffDelay returns the delay before retry attempt, doubling from min
+// up to max, with jitter so that writers do not retry at the same time.
+func backoffDelay(attempt int, min, max time.Duration) time.Duration {
+	if min <= 0 {
+		min = time.Millisecond
+	}
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max && max > 0 {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
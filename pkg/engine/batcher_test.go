@@ -0,0 +1,65 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcherRetryEntries(t *testing.T) {
+	var lock sync.Mutex
+	var sent [][]string
+	b := NewBatcher("test", BatchOptions{BatchSize: 10, BatchWait: 60000, Retries: 1, RetryMin: 1, RetryMax: 1},
+		func(entries []BatchEntry) error {
+			lock.Lock()
+			defer lock.Unlock()
+			var batch, retry []string
+			var again []BatchEntry
+			for _, e := range entries {
+				batch = append(batch, e.Key+":"+e.Text)
+				if e.Text != "ok" {
+					retry = append(retry, e.Text)
+					again = append(again, e)
+				}
+			}
+			sent = append(sent, batch)
+			if len(again) > 0 {
+				return &RetryEntries{Entries: again, Err: errors.New("busy")}
+			}
+			return nil
+		})
+	lm := &LogMsg{Level: LevelError, When: time.Now()}
+	b.AddKey(lm, "a", "ok")
+	b.AddKey(lm, "b", "busy")
+	b.Add(lm, "ok")
+	b.Flush()
+
+	lock.Lock()
+	assert.Equal(t, [][]string{{"a:ok", "b:busy", ":ok"}, {"b:busy"}}, sent)
+	lock.Unlock()
+	assert.Equal(t, BatchStats{Retried: 1, Failed: 1}, b.Stats())
+	b.Close()
+}
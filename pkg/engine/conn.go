@@ -21,16 +21,18 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 // connWriter implements LoggerInterface.
-// Writes messages in keep-live tcp connection.
+// Writes messages in keep-live tcp connection, optionally over TLS.
 type connWriter struct {
 	lg             *logWriter
 	innerWriter    io.WriteCloser
@@ -41,12 +43,33 @@ type connWriter struct {
 	Net            string `json:"net"`
 	Addr           string `json:"addr"`
 	Level          int    `json:"level"`
+	TLS            bool   `json:"tls"`
+	TLSOptions
+
+	Framing      string `json:"framing"`
+	DatagramSize int    `json:"datagramSize"`
+	Oversize     string `json:"oversize"`
+	framer       *framer
+
+	QueueSize     int    `json:"queueSize"`
+	SpillDir      string `json:"spillDir"`
+	SpillMaxBytes int64  `json:"spillMaxBytes"`
+	FlushTimeout  int    `json:"flushTimeout"`
+	RetryMin      int    `json:"retryMin"`
+	RetryMax      int    `json:"retryMax"`
+
+	queue *connQueue
+	stop  chan struct{}
+	done  chan struct{}
 }
 
 // NewConn creates new ConnWrite returning as LoggerInterface.
 func NewConn() Logger {
 	conn := new(connWriter)
 	conn.Level = LevelTrace
+	conn.FlushTimeout = 5000
+	conn.RetryMin = 100
+	conn.RetryMax = 30000
 	conn.formatter = conn
 	return conn
 }
@@ -57,6 +80,47 @@ func (c *connWriter) Format(lm *LogMsg) string {
 
 // Init initializes a connection writer with json config.
 // json config only needs they "level" key
+// TLS and mutual TLS are configured like:
+//
+//	{
+//	"net":"tcp",
+//	"addr":"logs.bhojpur.net:6514",
+//	"tls":true,
+//	"ca":"ca.pem",
+//	"cert":"client.pem",
+//	"key":"client-key.pem",
+//	"servername":"logs.bhojpur.net",
+//	"insecureSkipVerify":false
+//	}
+//
+// With a queueSize messages are delivered by a background sender that
+// retries with exponential backoff. Overflow goes to spillDir when set,
+// and is dropped otherwise. Durations are in milliseconds:
+//
+//	{
+//	"net":"tcp",
+//	"addr":":7020",
+//	"queueSize":1000,
+//	"spillDir":"/var/spool/app/logs",
+//	"spillMaxBytes":67108864,
+//	"flushTimeout":5000,
+//	"retryMin":100,
+//	"retryMax":30000
+//	}
+//
+// framing selects how messages are delimited on the wire: "newline"
+// (default), "octet" for RFC 6587 octet counting, "length" for a 4-byte
+// big endian length prefix or "nul". On udp and unixgram connections,
+// messages larger than datagramSize are cut according to oversize,
+// "truncate" (default) or "chunk":
+//
+//	{
+//	"net":"udp",
+//	"addr":"127.0.0.1:514",
+//	"framing":"octet",
+//	"datagramSize":1472,
+//	"oversize":"chunk"
+//	}
 func (c *connWriter) Init(config string) error {
 	res := json.Unmarshal([]byte(config), c)
 	if res == nil && len(c.Formatter) > 0 {
@@ -66,7 +130,17 @@ func (c *connWriter) Init(config string) error {
 		}
 		c.formatter = fmtr
 	}
-	return res
+	if res != nil {
+		return res
+	}
+	if c.framer, res = newFramer(c.Framing, c.Net, c.DatagramSize, c.Oversize); res != nil {
+		return res
+	}
+	c.stopSender()
+	if c.QueueSize > 0 {
+		return c.startSender()
+	}
+	return nil
 }
 
 func (c *connWriter) SetFormatter(f LogFormatter) {
@@ -75,10 +149,20 @@ func (c *connWriter) SetFormatter(f LogFormatter) {
 
 // WriteMsg writes message in connection.
 // If connection is down, try to re-connect.
+// With a queue the message is handed to the background sender instead.
 func (c *connWriter) WriteMsg(lm *LogMsg) error {
 	if lm.Level > c.Level {
 		return nil
 	}
+	msg := c.formatter.Format(lm)
+	if c.queue != nil {
+		c.queue.push(msg)
+		return nil
+	}
+	return c.send(msg)
+}
+
+func (c *connWriter) send(msg string) error {
 	if c.needToConnectOnMsg() {
 		err := c.connect()
 		if err != nil {
@@ -90,22 +174,38 @@ func (c *connWriter) WriteMsg(lm *LogMsg) error {
 		defer c.innerWriter.Close()
 	}
 
-	msg := c.formatter.Format(lm)
-
-	_, err := c.lg.writeln(msg)
-	if err != nil {
+	if c.framer == nil {
+		_, err := c.lg.writeln(msg)
 		return err
 	}
+	for _, frame := range c.framer.frames(msg) {
+		if _, err := c.lg.write(frame); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Flush implementing method. empty.
+// Flush waits until the queued messages are delivered, at most flushTimeout.
 func (c *connWriter) Flush() {
+	if c.queue != nil {
+		c.queue.wait(time.Duration(c.FlushTimeout) * time.Millisecond)
+	}
+}
 
+// Stats returns the delivery counters. They are all zero without a queue.
+func (c *connWriter) Stats() ConnStats {
+	if c.queue == nil {
+		return ConnStats{}
+	}
+	return c.queue.stats()
 }
 
 // Destroy destroy connection writer and close tcp listener.
+// Queued messages that could not be delivered are spilled or dropped.
 func (c *connWriter) Destroy() {
+	c.Flush()
+	c.stopSender()
 	if c.innerWriter != nil {
 		c.innerWriter.Close()
 	}
@@ -117,7 +217,18 @@ func (c *connWriter) connect() error {
 		c.innerWriter = nil
 	}
 
-	conn, err := net.Dial(c.Net, c.Addr)
+	var conn net.Conn
+	var err error
+	if c.TLS {
+		// the certificates are loaded again on each reconnect
+		cfg, cfgErr := c.ClientConfig(c.Addr)
+		if cfgErr != nil {
+			return cfgErr
+		}
+		conn, err = tls.Dial(c.Net, c.Addr, cfg)
+	} else {
+		conn, err = net.Dial(c.Net, c.Addr)
+	}
 	if err != nil {
 		return err
 	}
@@ -131,6 +242,68 @@ func (c *connWriter) connect() error {
 	return nil
 }
 
+func (c *connWriter) startSender() error {
+	var spill *spillQueue
+	if c.SpillDir != "" {
+		if c.SpillMaxBytes <= 0 {
+			c.SpillMaxBytes = defaultSpillMaxBytes
+		}
+		var err error
+		if spill, err = openSpillQueue(c.SpillDir, c.SpillMaxBytes); err != nil {
+			return err
+		}
+	}
+	c.queue = newConnQueue(c.QueueSize, spill)
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.sender(c.queue, c.stop, c.done)
+	return nil
+}
+
+func (c *connWriter) stopSender() {
+	if c.queue == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+	c.queue.close()
+	c.queue = nil
+}
+
+// sender delivers the queued messages in order, backing off while
+// the remote end is unreachable.
+func (c *connWriter) sender(q *connQueue, stop, done chan struct{}) {
+	defer close(done)
+	attempt := 0
+	for {
+		msg, ok := q.peek()
+		if !ok {
+			select {
+			case <-q.wake:
+				continue
+			case <-stop:
+				return
+			}
+		}
+		err := c.send(msg)
+		if err == nil {
+			q.pop()
+			attempt = 0
+			continue
+		}
+		if c.innerWriter != nil {
+			c.innerWriter.Close()
+			c.innerWriter = nil
+		}
+		select {
+		case <-time.After(backoffDelay(attempt, time.Duration(c.RetryMin)*time.Millisecond, time.Duration(c.RetryMax)*time.Millisecond)):
+			attempt++
+		case <-stop:
+			return
+		}
+	}
+}
+
 func (c *connWriter) needToConnectOnMsg() bool {
 	if c.Reconnect {
 		return true
@@ -0,0 +1,154 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Framing modes of the conn adapter.
+const (
+	FramingNewline = "newline" // message followed by '\n'
+	FramingOctet   = "octet"   // RFC 6587 octet counting: "<len> <message>"
+	FramingLength  = "length"  // 4-byte big endian length, then the message
+	FramingNUL     = "nul"     // message followed by '\x00'
+)
+
+// Handling of messages that do not fit in one datagram.
+const (
+	OversizeTruncate = "truncate"
+	OversizeChunk    = "chunk"
+)
+
+// defaultDatagramSize is the largest UDP payload.
+const defaultDatagramSize = 65507
+
+// truncatedMarker ends a message cut to fit in a datagram.
+const truncatedMarker = "...[truncated]"
+
+// framer turns a message into the frames written to the connection.
+type framer struct {
+	framing  string
+	datagram bool
+	size     int
+	oversize string
+}
+
+func newFramer(framing, network string, size int, oversize string) (*framer, error) {
+	switch framing {
+	case "":
+		framing = FramingNewline
+	case FramingNewline, FramingOctet, FramingLength, FramingNUL:
+	default:
+		return nil, fmt.Errorf("unknown framing: %s", framing)
+	}
+	switch oversize {
+	case "":
+		oversize = OversizeTruncate
+	case OversizeTruncate, OversizeChunk:
+	default:
+		return nil, fmt.Errorf("unknown oversize mode: %s", oversize)
+	}
+	if size <= 0 {
+		size = defaultDatagramSize
+	}
+	f := &framer{framing: framing, size: size, oversize: oversize}
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		f.datagram = true
+	}
+	if f.datagram && f.overhead(size) >= size {
+		return nil, fmt.Errorf("datagram size %d is too small", size)
+	}
+	return f, nil
+}
+
+// overhead returns the framing bytes added to a message of n bytes.
+func (f *framer) overhead(n int) int {
+	switch f.framing {
+	case FramingOctet:
+		return len(strconv.Itoa(n)) + 1
+	case FramingLength:
+		return 4
+	default:
+		return 1
+	}
+}
+
+func (f *framer) frame(msg string) []byte {
+	buf := make([]byte, 0, len(msg)+f.overhead(len(msg)))
+	switch f.framing {
+	case FramingOctet:
+		buf = append(strconv.AppendInt(buf, int64(len(msg)), 10), ' ')
+		buf = append(buf, msg...)
+	case FramingLength:
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(msg)))
+		buf = append(append(buf, hdr[:]...), msg...)
+	case FramingNUL:
+		buf = append(append(buf, msg...), 0)
+	default:
+		buf = append(append(buf, msg...), '\n')
+	}
+	return buf
+}
+
+// frames returns the frames for msg. On stream connections it is always
+// one frame; datagrams larger than the size limit are truncated with a
+// marker or split into several chunks.
+func (f *framer) frames(msg string) [][]byte {
+	room := f.size - f.overhead(f.size)
+	if !f.datagram || len(msg) <= room {
+		return [][]byte{f.frame(msg)}
+	}
+	if f.oversize == OversizeTruncate {
+		if room <= len(truncatedMarker) {
+			return [][]byte{f.frame(cutRunes(msg, room))}
+		}
+		return [][]byte{f.frame(cutRunes(msg, room-len(truncatedMarker)) + truncatedMarker)}
+	}
+	var frames [][]byte
+	for len(msg) > 0 {
+		chunk := cutRunes(msg, room)
+		if chunk == "" {
+			// a single rune does not fit, cut it anyway
+			chunk = msg[:room]
+		}
+		frames = append(frames, f.frame(chunk))
+		msg = msg[len(chunk):]
+	}
+	return frames
+}
+
+// cutRunes returns the longest prefix of s with at most n bytes that
+// does not split a UTF-8 sequence.
+func cutRunes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
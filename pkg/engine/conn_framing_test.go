@@ -0,0 +1,89 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramerFrames(t *testing.T) {
+	msg := "panic: boom\n\tat main.go:13"
+	cases := map[string]string{
+		FramingNewline: msg + "\n",
+		FramingOctet:   "26 " + msg,
+		FramingLength:  "\x00\x00\x00\x1a" + msg,
+		FramingNUL:     msg + "\x00",
+	}
+	for framing, want := range cases {
+		f, err := newFramer(framing, "tcp", 0, "")
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte(want)}, f.frames(msg), framing)
+	}
+
+	_, err := newFramer("json", "tcp", 0, "")
+	assert.NotNil(t, err)
+	_, err = newFramer("", "udp", 0, "drop")
+	assert.NotNil(t, err)
+}
+
+func TestFramerDatagram(t *testing.T) {
+	f, err := newFramer(FramingNUL, "udp", 8, OversizeChunk)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("abcdefg\x00"), []byte("hij\x00")}, f.frames("abcdefghij"))
+	// multi-byte runes are never split
+	assert.Equal(t, [][]byte{[]byte("abcdef\x00"), []byte("éhij\x00")}, f.frames("abcdeféhij"))
+
+	f, err = newFramer(FramingNewline, "unixgram", 20, OversizeTruncate)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("abcde" + truncatedMarker + "\n")}, f.frames(strings.Repeat("abcdefghij", 3)))
+
+	// streams are never cut
+	f, err = newFramer(FramingNewline, "tcp", 8, OversizeTruncate)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(f.frames(strings.Repeat("x", 100))))
+}
+
+func TestConnFramingUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	cw := NewConn()
+	assert.Nil(t, cw.Init(fmt.Sprintf(`{"net":"udp","addr":"%s","framing":"octet","datagramSize":16,"oversize":"chunk"}`, pc.LocalAddr())))
+	defer cw.Destroy()
+	assert.Nil(t, cw.WriteMsg(&LogMsg{Level: LevelError, Msg: "line 1\nline 2", When: time.Now()}))
+
+	var got []string
+	buf := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		assert.Nil(t, err)
+		got = append(got, string(buf[:n]))
+	}
+	assert.Equal(t, []string{"13 [E]  line 1\nl", "5 ine 2"}, got)
+}
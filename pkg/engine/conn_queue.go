@@ -0,0 +1,456 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpillMaxBytes = 64 << 20
+	spillSegmentBytes    = 1 << 20
+	// numbering starts high enough to leave room for segments put in
+	// front of the queue when a writer is destroyed while spilling
+	firstSpillSegment = 1 << 20
+)
+
+// ConnStats are the delivery counters of a conn adapter running with a queue.
+type ConnStats struct {
+	Sent    uint64 // messages delivered
+	Dropped uint64 // messages lost because the queue and the spill were full
+	Spilled uint64 // messages written to the spill directory
+	Queued  int    // messages waiting in memory or on disk
+}
+
+// connQueue buffers the messages of a conn adapter until the sender
+// delivers them. Once the memory queue overflows, messages go to the
+// spill until it has been replayed completely, so the order is kept.
+type connQueue struct {
+	lock     sync.Mutex
+	msgs     []string
+	size     int
+	spill    *spillQueue // nil without a spill directory
+	spilling bool
+	pending  int // queued, spilled or being sent
+	wake     chan struct{}
+	idle     chan struct{} // closed when pending drops to 0
+
+	sent, dropped, spilled uint64
+}
+
+func newConnQueue(size int, spill *spillQueue) *connQueue {
+	q := &connQueue{
+		size:  size,
+		spill: spill,
+		wake:  make(chan struct{}, 1),
+		idle:  make(chan struct{}),
+	}
+	if spill != nil && spill.records > 0 {
+		// replay what a previous run left on disk first
+		q.spilling = true
+		q.pending = spill.records
+	}
+	if q.pending == 0 {
+		close(q.idle)
+	}
+	return q
+}
+
+// push queues msg, spilling or dropping it when the memory queue is full.
+func (q *connQueue) push(msg string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	switch {
+	case !q.spilling && len(q.msgs) < q.size:
+		q.msgs = append(q.msgs, msg)
+	case q.spill != nil && q.spill.write(msg) == nil:
+		q.spilling = true
+		q.spilled++
+	default:
+		q.dropped++
+		return
+	}
+	if q.pending == 0 {
+		q.idle = make(chan struct{})
+	}
+	q.pending++
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// peek returns the oldest message without removing it.
+func (q *connQueue) peek() (string, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.msgs) > 0 {
+		return q.msgs[0], true
+	}
+	if q.spilling {
+		msg, err := q.spill.peek()
+		if err == nil {
+			return msg, true
+		}
+		if err != io.EOF {
+			fmt.Fprintf(os.Stderr, "connWriter spill(%q): %s\n", q.spill.dir, err)
+		}
+		// nothing readable is left on disk
+		q.pending -= q.spill.records
+		q.spill.reset()
+		q.spilling = false
+		q.markIdle()
+	}
+	return "", false
+}
+
+// pop removes the message returned by peek once it has been delivered.
+func (q *connQueue) pop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.msgs) > 0 {
+		q.msgs[0] = ""
+		q.msgs = q.msgs[1:]
+	} else if q.spilling {
+		q.spill.pop()
+		if q.spill.records == 0 {
+			q.spill.reset()
+			q.spilling = false
+		}
+	}
+	q.sent++
+	q.pending--
+	q.markIdle()
+}
+
+func (q *connQueue) markIdle() {
+	if q.pending == 0 {
+		select {
+		case <-q.idle:
+		default:
+			close(q.idle)
+		}
+	}
+}
+
+// wait blocks until every queued message has been delivered or timeout expires.
+func (q *connQueue) wait(timeout time.Duration) bool {
+	q.lock.Lock()
+	idle := q.idle
+	q.lock.Unlock()
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// close persists the messages left in memory to the spill, or drops them.
+func (q *connQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	msgs := q.msgs
+	q.msgs = nil
+	if q.spill == nil {
+		q.dropped += uint64(len(msgs))
+		return
+	}
+	if err := q.spill.compact(); err != nil {
+		fmt.Fprintf(os.Stderr, "connWriter spill(%q): %s\n", q.spill.dir, err)
+	}
+	// messages left in memory are older than any spilled one
+	if err := q.spill.writeFront(msgs); err != nil {
+		fmt.Fprintf(os.Stderr, "connWriter spill(%q): %s\n", q.spill.dir, err)
+		q.dropped += uint64(len(msgs))
+	}
+	q.spill.close()
+}
+
+func (q *connQueue) stats() ConnStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return ConnStats{
+		Sent:    q.sent,
+		Dropped: q.dropped,
+		Spilled: q.spilled,
+		Queued:  q.pending,
+	}
+}
+
+// spillQueue is an on-disk FIFO made of numbered segment files holding
+// length-prefixed messages. Segments are deleted once replayed.
+type spillQueue struct {
+	dir      string
+	maxBytes int64
+	bytes    int64
+	records  int
+
+	segments []int // segment numbers, oldest first
+	writer   *os.File
+	wsize    int64
+
+	reader  *os.File
+	breader *bufio.Reader
+	next    string // message returned by peek
+	nextLen int64
+	hasNext bool
+}
+
+func segmentName(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("segment-%010d.spill", n))
+}
+
+// openSpillQueue opens the spill directory and counts the messages a
+// previous run left in it.
+func openSpillQueue(dir string, maxBytes int64) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	s := &spillQueue{dir: dir, maxBytes: maxBytes}
+	names, err := filepath.Glob(filepath.Join(dir, "segment-*.spill"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(name), "segment-%d.spill", &n); err != nil {
+			continue
+		}
+		count, size, err := countSpillRecords(name)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, n)
+		s.records += count
+		s.bytes += size
+	}
+	sort.Ints(s.segments)
+	return s, nil
+}
+
+func countSpillRecords(name string) (int, int64, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+	r := bufio.NewReader(fd)
+	count, size := 0, int64(0)
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			// a torn record at the end is ignored
+			return count, size, nil
+		}
+		n := int64(binary.BigEndian.Uint32(hdr[:]))
+		if _, err := r.Discard(int(n)); err != nil {
+			return count, size, nil
+		}
+		count++
+		size += n + 4
+	}
+}
+
+func appendSpillRecord(buf []byte, msg string) []byte {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(msg)))
+	return append(append(buf, hdr[:]...), msg...)
+}
+
+func (s *spillQueue) write(msg string) error {
+	n := int64(len(msg)) + 4
+	if s.bytes+n > s.maxBytes {
+		return fmt.Errorf("spill directory %s is full", s.dir)
+	}
+	if s.writer == nil || s.wsize+n > spillSegmentBytes {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.writer.Write(appendSpillRecord(make([]byte, 0, n), msg)); err != nil {
+		return err
+	}
+	s.wsize += n
+	s.bytes += n
+	s.records++
+	return nil
+}
+
+// roll starts a new segment for writing.
+func (s *spillQueue) roll() error {
+	if s.writer != nil {
+		s.writer.Close()
+	}
+	n := firstSpillSegment
+	if len(s.segments) > 0 {
+		n = s.segments[len(s.segments)-1] + 1
+	}
+	fd, err := os.OpenFile(segmentName(s.dir, n), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		s.writer = nil
+		return err
+	}
+	s.segments = append(s.segments, n)
+	s.writer, s.wsize = fd, 0
+	return nil
+}
+
+// writeFront stores msgs in a new segment ahead of all others.
+func (s *spillQueue) writeFront(msgs []string) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	size := int64(0)
+	for _, msg := range msgs {
+		size += int64(len(msg)) + 4
+	}
+	if s.bytes+size > s.maxBytes {
+		return fmt.Errorf("spill directory %s is full", s.dir)
+	}
+	n := firstSpillSegment
+	if len(s.segments) > 0 {
+		n = s.segments[0] - 1
+	}
+	if n < 0 {
+		return fmt.Errorf("no segment number left in spill directory %s", s.dir)
+	}
+	buf := make([]byte, 0, size)
+	for _, msg := range msgs {
+		buf = appendSpillRecord(buf, msg)
+	}
+	if err := os.WriteFile(segmentName(s.dir, n), buf, 0640); err != nil {
+		return err
+	}
+	s.segments = append([]int{n}, s.segments...)
+	s.bytes += size
+	s.records += len(msgs)
+	return nil
+}
+
+// peek reads the oldest message. It returns io.EOF when the spill is empty.
+func (s *spillQueue) peek() (string, error) {
+	if s.hasNext {
+		return s.next, nil
+	}
+	for len(s.segments) > 0 {
+		if s.reader == nil {
+			fd, err := os.Open(segmentName(s.dir, s.segments[0]))
+			if err != nil {
+				return "", err
+			}
+			s.reader, s.breader = fd, bufio.NewReader(fd)
+		}
+		var hdr [4]byte
+		_, err := io.ReadFull(s.breader, hdr[:])
+		if err == nil {
+			buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+			if _, err = io.ReadFull(s.breader, buf); err == nil {
+				s.next, s.nextLen, s.hasNext = string(buf), int64(len(buf))+4, true
+				return s.next, nil
+			}
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		if len(s.segments) == 1 && s.writer != nil {
+			// caught up with the segment being written
+			return "", io.EOF
+		}
+		s.dropSegment()
+	}
+	return "", io.EOF
+}
+
+func (s *spillQueue) pop() {
+	if !s.hasNext {
+		return
+	}
+	s.hasNext = false
+	s.next = ""
+	s.bytes -= s.nextLen
+	s.records--
+}
+
+// dropSegment deletes the oldest segment, which has been replayed.
+func (s *spillQueue) dropSegment() {
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader, s.breader = nil, nil
+	}
+	if len(s.segments) == 1 && s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	os.Remove(segmentName(s.dir, s.segments[0]))
+	s.segments = s.segments[1:]
+}
+
+// reset deletes all segments once the spill has been replayed.
+func (s *spillQueue) reset() {
+	for len(s.segments) > 0 {
+		s.dropSegment()
+	}
+	s.bytes, s.records, s.hasNext = 0, 0, false
+}
+
+// compact rewrites the segment being replayed without the messages
+// already delivered, so they are not sent again after a restart.
+func (s *spillQueue) compact() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	if s.reader == nil {
+		return nil
+	}
+	var rest []byte
+	if s.hasNext {
+		rest = appendSpillRecord(rest, s.next)
+		s.hasNext = false
+	}
+	tail, err := io.ReadAll(s.breader)
+	s.reader.Close()
+	s.reader, s.breader = nil, nil
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(segmentName(s.dir, s.segments[0]), append(rest, tail...), 0640)
+}
+
+func (s *spillQueue) close() {
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader, s.breader = nil, nil
+	}
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+}
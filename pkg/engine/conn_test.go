@@ -21,6 +21,9 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"os"
 	"testing"
@@ -101,3 +104,170 @@ func TestConnWriter_Format(t *testing.T) {
 	res := cw.Format(lg)
 	assert.Equal(t, "[D] Cus Hello, world", res)
 }
+
+func TestConnTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issue(t, "client", false)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", pki.serverConfig(t))
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	type received struct {
+		line   string
+		client string
+		serial int64
+	}
+	lines := make(chan received, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					peer := conn.(*tls.Conn).ConnectionState().PeerCertificates[0]
+					lines <- received{line, peer.Subject.CommonName, peer.SerialNumber.Int64()}
+				}
+			}(conn)
+		}
+	}()
+
+	config := fmt.Sprintf(`{"net":"tcp","addr":"%s","tls":true,"reconnectOnMsg":true,"ca":"%s","cert":"%s","key":"%s","servername":"localhost"}`,
+		ln.Addr().String(), pki.path("ca.pem"), pki.path("client.pem"), pki.path("client-key.pem"))
+	cw := NewConn()
+	assert.Nil(t, cw.Init(config))
+	defer cw.Destroy()
+
+	lm := &LogMsg{Level: LevelInfo, Msg: "over tls", When: time.Now()}
+	assert.Nil(t, cw.WriteMsg(lm))
+	first := <-lines
+	assert.Equal(t, "[I]  over tls\n", first.line)
+	assert.Equal(t, "client", first.client)
+
+	// the renewed client certificate is used on reconnect
+	pki.issue(t, "client", false)
+	assert.Nil(t, cw.WriteMsg(lm))
+	second := <-lines
+	assert.NotEqual(t, first.serial, second.serial)
+}
+
+func TestConnTLSUnknownCA(t *testing.T) {
+	pki := newTestPKI(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pki.issue(t, "server", true)}})
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	cw := NewConn()
+	assert.Nil(t, cw.Init(fmt.Sprintf(`{"net":"tcp","addr":"%s","tls":true}`, ln.Addr().String())))
+	err = cw.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "over tls", When: time.Now()})
+	assert.NotNil(t, err)
+}
+
+// freeAddr returns a local address nobody listens on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// acceptLines listens on addr and sends every received line to the channel.
+func acceptLines(t *testing.T, addr string) (net.Listener, <-chan string) {
+	ln, err := net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- line
+				}
+			}(conn)
+		}
+	}()
+	return ln, lines
+}
+
+func TestConnQueueRetry(t *testing.T) {
+	addr := freeAddr(t)
+	cw := NewConn().(*connWriter)
+	assert.Nil(t, cw.Init(fmt.Sprintf(`{"net":"tcp","addr":"%s","queueSize":10,"retryMin":10,"retryMax":50,"flushTimeout":3000}`, addr)))
+	defer cw.Destroy()
+
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, cw.WriteMsg(&LogMsg{Level: LevelInfo, Msg: fmt.Sprintf("msg %d", i), When: time.Now()}))
+	}
+	assert.Equal(t, ConnStats{Queued: 3}, cw.Stats())
+
+	ln, lines := acceptLines(t, addr)
+	defer ln.Close()
+	cw.Flush()
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, fmt.Sprintf("[I]  msg %d\n", i), <-lines)
+	}
+	assert.Equal(t, ConnStats{Sent: 3}, cw.Stats())
+}
+
+func TestConnQueueDrop(t *testing.T) {
+	cw := NewConn().(*connWriter)
+	assert.Nil(t, cw.Init(fmt.Sprintf(`{"net":"tcp","addr":"%s","queueSize":1,"flushTimeout":10}`, freeAddr(t))))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cw.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "lost", When: time.Now()}))
+	}
+	assert.Equal(t, ConnStats{Dropped: 2, Queued: 1}, cw.Stats())
+	cw.Destroy()
+}
+
+func TestConnQueueSpill(t *testing.T) {
+	addr := freeAddr(t)
+	config := fmt.Sprintf(`{"net":"tcp","addr":"%s","queueSize":2,"spillDir":"%s","retryMin":10,"retryMax":50,"flushTimeout":3000}`,
+		addr, t.TempDir())
+
+	cw := NewConn().(*connWriter)
+	assert.Nil(t, cw.Init(config))
+	for i := 1; i <= 5; i++ {
+		assert.Nil(t, cw.WriteMsg(&LogMsg{Level: LevelInfo, Msg: fmt.Sprintf("msg %d", i), When: time.Now()}))
+	}
+	assert.Equal(t, ConnStats{Spilled: 3, Queued: 5}, cw.Stats())
+	cw.FlushTimeout = 10
+	cw.Destroy()
+
+	// a new writer replays the spill, including the messages that were
+	// still in memory, before the new ones, which queue up behind it
+	ln, lines := acceptLines(t, addr)
+	defer ln.Close()
+	cw = NewConn().(*connWriter)
+	assert.Nil(t, cw.Init(config))
+	defer cw.Destroy()
+	assert.Nil(t, cw.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "msg 6", When: time.Now()}))
+	cw.Flush()
+	for i := 1; i <= 6; i++ {
+		assert.Equal(t, fmt.Sprintf("[I]  msg %d\n", i), <-lines)
+	}
+	assert.Equal(t, ConnStats{Sent: 6, Spilled: 1}, cw.Stats())
+}
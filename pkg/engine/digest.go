@@ -0,0 +1,213 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DigestOptions holds the digest settings of the notification adapters.
+// With digest on, messages are grouped by level, message template and
+// caller, and sent as one summary when the window (in milliseconds)
+// ends or digestSize messages have arrived. digestFirst sends the first
+// message of each group at once as well.
+type DigestOptions struct {
+	Digest       bool `json:"digest"`
+	DigestWindow int  `json:"digestWindow"`
+	DigestSize   int  `json:"digestSize"`
+	DigestFirst  bool `json:"digestFirst"`
+}
+
+func defaultDigestOptions() DigestOptions {
+	return DigestOptions{
+		DigestWindow: 300000,
+		DigestSize:   100,
+	}
+}
+
+// digestGroup counts the messages sharing one template and caller.
+type digestGroup struct {
+	level  int
+	msg    string // the template, before its arguments are applied
+	file   string
+	line   int
+	sample string // the first message with its arguments
+	count  int
+	first  time.Time
+	last   time.Time
+	sent   bool // the first message was sent on its own
+}
+
+// digester collects messages into digests and hands each finished
+// digest, or a message sent at once, to emit.
+type digester struct {
+	opts DigestOptions
+	emit func(lm *LogMsg, text string)
+
+	emitLock sync.Mutex // one digest is sent at a time
+
+	lock   sync.Mutex
+	groups map[string]*digestGroup
+	order  []*digestGroup
+	total  int
+	start  time.Time
+	timer  *time.Timer
+	closed bool
+}
+
+func newDigester(opts DigestOptions, emit func(*LogMsg, string)) *digester {
+	if opts.DigestWindow <= 0 {
+		opts.DigestWindow = defaultDigestOptions().DigestWindow
+	}
+	if opts.DigestSize <= 0 {
+		opts.DigestSize = defaultDigestOptions().DigestSize
+	}
+	return &digester{
+		opts:   opts,
+		emit:   emit,
+		groups: map[string]*digestGroup{},
+	}
+}
+
+// add counts lm in the current digest. text is lm formatted by the
+// adapter, sent when lm opens its group and digestFirst is on.
+func (d *digester) add(lm *LogMsg, text string) {
+	key := fmt.Sprintf("%d\x00%s\x00%s\x00%d", lm.Level, lm.Msg, lm.FilePath, lm.LineNumber)
+
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return
+	}
+	if d.total == 0 {
+		d.start = lm.When
+		d.timer = time.AfterFunc(time.Duration(d.opts.DigestWindow)*time.Millisecond, d.Flush)
+	}
+	d.total++
+	g, ok := d.groups[key]
+	if !ok {
+		g = &digestGroup{
+			level:  lm.Level,
+			msg:    lm.Msg,
+			file:   lm.FilePath,
+			line:   lm.LineNumber,
+			sample: lm.Message(),
+			first:  lm.When,
+			sent:   d.opts.DigestFirst,
+		}
+		d.groups[key] = g
+		d.order = append(d.order, g)
+	}
+	g.count++
+	g.last = lm.When
+	full := d.total >= d.opts.DigestSize
+	d.lock.Unlock()
+
+	if !ok && d.opts.DigestFirst {
+		d.emit(lm, text)
+	}
+	if full {
+		d.Flush()
+	}
+}
+
+// take removes the current digest, returning nil when nothing is left
+// to report.
+func (d *digester) take() (*LogMsg, string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.total == 0 {
+		return nil, ""
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	order, total, start := d.order, d.total, d.start
+	d.groups = map[string]*digestGroup{}
+	d.order = nil
+	d.total = 0
+
+	// groups seen once and already sent have nothing to add
+	report := false
+	for _, g := range order {
+		if !g.sent || g.count > 1 {
+			report = true
+			break
+		}
+	}
+	if !report {
+		return nil, ""
+	}
+	return digestSummary(order, total, start)
+}
+
+// digestSummary returns the summary message of a digest, at the most
+// severe level among its groups.
+func digestSummary(groups []*digestGroup, total int, start time.Time) (*LogMsg, string) {
+	lm := &LogMsg{Level: LevelDebug, When: start}
+	for _, g := range groups {
+		if g.level < lm.Level {
+			lm.Level = g.level
+		}
+		if g.last.After(lm.When) {
+			lm.When = g.last
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d messages in %d groups from %s to %s",
+		total, len(groups), start.Format("2006-01-02 15:04:05"), lm.When.Format("2006-01-02 15:04:05"))
+	for _, g := range groups {
+		prefix := ""
+		if g.level >= 0 && g.level <= LevelDebug {
+			prefix = levelPrefix[g.level] + " "
+		}
+		caller := ""
+		if g.file != "" {
+			caller = fmt.Sprintf(" [%s:%d]", path.Base(g.file), g.line)
+		}
+		fmt.Fprintf(&b, "\n%dx %s%s%s (first %s, last %s)",
+			g.count, prefix, g.sample, caller, g.first.Format("15:04:05"), g.last.Format("15:04:05"))
+	}
+	lm.Msg = b.String()
+	return lm, lm.Msg
+}
+
+// Flush sends the current digest now.
+func (d *digester) Flush() {
+	d.emitLock.Lock()
+	defer d.emitLock.Unlock()
+	if lm, text := d.take(); lm != nil {
+		d.emit(lm, text)
+	}
+}
+
+// close sends the current digest and stops collecting.
+func (d *digester) close() {
+	d.lock.Lock()
+	d.closed = true
+	d.lock.Unlock()
+	d.Flush()
+}
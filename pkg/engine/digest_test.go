@@ -0,0 +1,162 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type digestRecorder struct {
+	lock  sync.Mutex
+	texts []string
+	lms   []LogMsg
+}
+
+func (r *digestRecorder) emit(lm *LogMsg, text string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.texts = append(r.texts, text)
+	r.lms = append(r.lms, *lm)
+}
+
+func TestDigestGroups(t *testing.T) {
+	rec := &digestRecorder{}
+	d := newDigester(DigestOptions{DigestWindow: 60000, DigestSize: 5}, rec.emit)
+	start := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		d.add(&LogMsg{Level: LevelWarning, Msg: "retry %d", Args: []interface{}{i}, When: start.Add(time.Duration(i) * time.Second), FilePath: "/src/db.go", LineNumber: 42}, "")
+	}
+	d.add(&LogMsg{Level: LevelError, Msg: "down", When: start.Add(5 * time.Second)}, "")
+	assert.Empty(t, rec.texts)
+
+	// the fifth message fills the digest
+	d.add(&LogMsg{Level: LevelWarning, Msg: "retry %d", Args: []interface{}{9}, When: start.Add(9 * time.Second), FilePath: "/src/db.go", LineNumber: 42}, "")
+	assert.Equal(t, []string{
+		"5 messages in 2 groups from 2021-03-04 10:00:00 to 2021-03-04 10:00:09\n" +
+			"4x [W] retry 0 [db.go:42] (first 10:00:00, last 10:00:09)\n" +
+			"1x [E] down (first 10:00:05, last 10:00:05)",
+	}, rec.texts)
+	assert.Equal(t, LevelError, rec.lms[0].Level)
+
+	d.Flush()
+	assert.Len(t, rec.texts, 1)
+	d.close()
+	d.add(&LogMsg{Level: LevelError, Msg: "late", When: start}, "")
+	d.Flush()
+	assert.Len(t, rec.texts, 1)
+}
+
+func TestDigestFirst(t *testing.T) {
+	rec := &digestRecorder{}
+	d := newDigester(DigestOptions{DigestWindow: 60000, DigestSize: 100, DigestFirst: true}, rec.emit)
+	d.add(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}, "first down")
+	d.add(&LogMsg{Level: LevelError, Msg: "once", When: time.Now()}, "first once")
+	d.add(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}, "second down")
+	assert.Equal(t, []string{"first down", "first once"}, rec.texts)
+
+	d.Flush()
+	assert.Len(t, rec.texts, 3)
+	assert.True(t, strings.HasPrefix(rec.texts[2], "3 messages in 2 groups"))
+
+	// a window of messages already sent one by one has no summary
+	d.add(&LogMsg{Level: LevelError, Msg: "alone", When: time.Now()}, "first alone")
+	d.close()
+	assert.Equal(t, "first alone", rec.texts[3])
+	assert.Len(t, rec.texts, 4)
+}
+
+// digestAdapter adds every message it gets to a digester.
+type digestAdapter struct {
+	d *digester
+}
+
+func (a *digestAdapter) Init(config string) error    { return nil }
+func (a *digestAdapter) Destroy()                    { a.d.close() }
+func (a *digestAdapter) Flush()                      { a.d.Flush() }
+func (a *digestAdapter) SetFormatter(f LogFormatter) {}
+
+func (a *digestAdapter) WriteMsg(lm *LogMsg) error {
+	a.d.add(lm, lm.Message())
+	return nil
+}
+
+func TestDigestPackageFunctions(t *testing.T) {
+	rec := &digestRecorder{}
+	adapter := &digestAdapter{d: newDigester(DigestOptions{DigestWindow: 60000, DigestSize: 100}, rec.emit)}
+	Register("test-digest", func() Logger { return adapter })
+	old := bhojpurLogger
+	bhojpurLogger = NewLogger()
+	defer func() { bhojpurLogger = old }()
+	assert.Nil(t, SetLogger("test-digest"))
+
+	for i := 0; i < 3; i++ {
+		Error("order %d failed", i)
+	}
+	for i := 0; i < 2; i++ {
+		Warn("retrying", i)
+	}
+	Error("disk full")
+	bhojpurLogger.Flush()
+
+	assert.Len(t, rec.texts, 1)
+	lines := strings.Split(rec.texts[0], "\n")
+	assert.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[0], "6 messages in 3 groups"))
+	assert.True(t, strings.HasPrefix(lines[1], "3x [E] order 0 failed [digest_test.go:"))
+	assert.True(t, strings.HasPrefix(lines[2], "2x [W] retrying 0 [digest_test.go:"))
+	assert.True(t, strings.HasPrefix(lines[3], "1x [E] disk full [digest_test.go:"))
+}
+
+func TestDigestWindow(t *testing.T) {
+	rec := &digestRecorder{}
+	d := newDigester(DigestOptions{DigestWindow: 50, DigestSize: 100}, rec.emit)
+	defer d.close()
+	d.add(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}, "")
+	assert.Eventually(t, func() bool {
+		rec.lock.Lock()
+		defer rec.lock.Unlock()
+		return len(rec.texts) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSLACKWriterDigest(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	s := newSLACKWriter()
+	assert.Nil(t, s.Init(fmt.Sprintf(`{"webhookurl":"%s","digest":true,"digestSize":100}`, srv.URL)))
+	for i := 0; i < 50; i++ {
+		s.WriteMsg(&LogMsg{Level: LevelError, Msg: "to slack %d", Args: []interface{}{i}, When: time.Now()})
+	}
+	s.Destroy()
+
+	payloads := slackPayloads(t, rec)
+	assert.Len(t, payloads, 1)
+	assert.Contains(t, payloads[0]["text"], "50x [E] to slack 0")
+}
@@ -0,0 +1,203 @@
+package es
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+
+	logs "github.com/bhojpur/logger/pkg/engine"
+)
+
+// BulkStats holds the counters of an es adapter.
+type BulkStats struct {
+	Indexed uint64 // documents accepted by Elasticsearch
+	Failed  uint64 // documents rejected, or given up after the retries
+	Retried uint64 // document retries
+	Dropped uint64 // documents dropped because the queue was full
+	Queued  int    // documents waiting to be sent
+}
+
+func defaultBatchOptions() logs.BatchOptions {
+	return logs.BatchOptions{
+		BatchSize:  500,
+		BatchBytes: 5 << 20,
+		BatchWait:  1000,
+		QueueSize:  10000,
+		Retries:    3,
+		RetryMin:   500,
+		RetryMax:   30000,
+	}
+}
+
+// bulkIndexer sends documents through the _bulk API, batched by a
+// logs.Batcher. Documents rejected with 429 or 5xx, and whole requests
+// that fail, are retried with backoff up to Retries times.
+type bulkIndexer struct {
+	client  *elasticsearch.Client
+	timeout time.Duration
+	op      string // bulk action, create for data streams
+	typed   bool   // documents carry a _type, for Elasticsearch 6
+	batcher *logs.Batcher
+
+	lock     sync.Mutex
+	indexed  uint64
+	rejected uint64
+}
+
+func newBulkIndexer(client *elasticsearch.Client, opts logs.BatchOptions, timeout time.Duration, op string, typed bool) *bulkIndexer {
+	b := &bulkIndexer{
+		client:  client,
+		timeout: timeout,
+		op:      op,
+		typed:   typed,
+	}
+	b.batcher = logs.NewBatcher("es", opts, b.send)
+	return b
+}
+
+// add queues the document of lm for index.
+func (b *bulkIndexer) add(lm *logs.LogMsg, index string, doc []byte) {
+	b.batcher.AddKey(lm, index, string(doc))
+}
+
+func (b *bulkIndexer) count(indexed, rejected int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.indexed += uint64(indexed)
+	b.rejected += uint64(rejected)
+}
+
+// bulkResponse is the part of the _bulk response telling how each
+// document fared.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// send posts batch, asking the batcher to retry the documents worth it.
+func (b *bulkIndexer) send(batch []logs.BatchEntry) error {
+	var body bytes.Buffer
+	for _, e := range batch {
+		action := map[string]string{"_index": e.Key}
+		if b.typed {
+			action["_type"] = "logs"
+		}
+		meta, _ := json.Marshal(map[string]interface{}{b.op: action})
+		body.Write(meta)
+		body.WriteByte('\n')
+		body.WriteString(e.Text)
+		body.WriteByte('\n')
+	}
+
+	ctx := context.Background()
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	res, err := esapi.BulkRequest{Body: &body}.Do(ctx, b.client)
+	if err != nil {
+		return &logs.RetryEntries{Entries: batch, Err: err}
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return &logs.RetryEntries{Entries: batch, Err: err}
+	}
+	if res.IsError() {
+		err = fmt.Errorf("bulk request: %s: %s", res.Status(), bytes.TrimSpace(data))
+		if retryable(res.StatusCode) {
+			return &logs.RetryEntries{Entries: batch, Err: err}
+		}
+		b.count(0, len(batch))
+		fmt.Fprintf(os.Stderr, "es: dropping %d documents: %s\n", len(batch), err)
+		return nil
+	}
+
+	var br bulkResponse
+	if err = json.Unmarshal(data, &br); err != nil {
+		return &logs.RetryEntries{Entries: batch, Err: err}
+	}
+	if len(br.Items) != len(batch) {
+		return &logs.RetryEntries{Entries: batch, Err: fmt.Errorf("bulk response has %d items for %d documents", len(br.Items), len(batch))}
+	}
+	var retry []logs.BatchEntry
+	indexed, rejected := 0, 0
+	for i, item := range br.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+				indexed++
+			case retryable(result.Status):
+				retry = append(retry, batch[i])
+				err = fmt.Errorf("status %d: %s", result.Status, result.Error)
+			default:
+				rejected++
+				fmt.Fprintf(os.Stderr, "es: document for %s rejected with status %d: %s\n", batch[i].Key, result.Status, result.Error)
+			}
+		}
+	}
+	b.count(indexed, rejected)
+	if len(retry) > 0 {
+		return &logs.RetryEntries{Entries: retry, Err: err}
+	}
+	return nil
+}
+
+func retryable(status int) bool {
+	return status == 429 || status >= 500
+}
+
+// Flush blocks until every queued document has been indexed or given up.
+func (b *bulkIndexer) Flush() {
+	b.batcher.Flush()
+}
+
+// close sends what is queued and stops the indexer.
+func (b *bulkIndexer) close() {
+	b.batcher.Close()
+}
+
+func (b *bulkIndexer) Stats() BulkStats {
+	bs := b.batcher.Stats()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return BulkStats{
+		Indexed: b.indexed,
+		Failed:  b.rejected + bs.Failed,
+		Retried: bs.Retried,
+		Dropped: bs.Dropped,
+		Queued:  bs.Queued,
+	}
+}
@@ -0,0 +1,176 @@
+package es
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	logs "github.com/bhojpur/logger/pkg/engine"
+)
+
+// fakeBulk stands in for a cluster of the given version. It answers
+// _bulk requests with the item status from status and records the
+// bulk actions, the Authorization headers and the other requests.
+type fakeBulk struct {
+	lock         sync.Mutex
+	version      string
+	distribution string
+	status       func(doc map[string]interface{}, attempt int) int
+	attempts     map[string]int
+	indexed      []string
+	docs         []map[string]interface{}
+	actions      []string
+	auth         []string
+	others       map[string]string // method and path to body
+	requests     int               // bulk requests
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"version": map[string]string{"number": f.version, "distribution": f.distribution},
+		})
+		return
+	case r.URL.Path != "/_bulk":
+		body, _ := ioutil.ReadAll(r.Body)
+		f.others[r.Method+" "+r.URL.Path] = string(body)
+		w.Write([]byte(`{"acknowledged":true}`))
+		return
+	}
+	f.requests++
+	var items []interface{}
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		f.actions = append(f.actions, sc.Text())
+		var meta map[string]map[string]string
+		json.Unmarshal(sc.Bytes(), &meta)
+		index := ""
+		for _, action := range meta {
+			index = action["_index"]
+		}
+		sc.Scan()
+		var doc map[string]interface{}
+		json.Unmarshal(sc.Bytes(), &doc)
+		f.docs = append(f.docs, doc)
+		msg, _ := doc["msg"].(string)
+		status := 201
+		if f.status != nil {
+			status = f.status(doc, f.attempts[msg])
+		}
+		f.attempts[msg]++
+		if status < 300 {
+			f.indexed = append(f.indexed, index+" "+msg)
+		}
+		items = append(items, map[string]interface{}{"index": map[string]interface{}{"status": status}})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+}
+
+func newFakeBulk(t *testing.T, status func(map[string]interface{}, int) int) (*fakeBulk, string) {
+	f := &fakeBulk{version: "8.5.0", status: status, attempts: map[string]int{}, others: map[string]string{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv.URL + "/"
+}
+
+func TestBulkIndexer(t *testing.T) {
+	f, dsn := newFakeBulk(t, func(doc map[string]interface{}, attempt int) int {
+		switch doc["msg"] {
+		case "busy":
+			if attempt < 2 {
+				return 429
+			}
+		case "bad":
+			return 400
+		}
+		return 201
+	})
+
+	l := NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","batchSize":10,"batchWait":10000,"retryMin":1,"retryMax":5}`, dsn)))
+	when := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	for _, msg := range []string{"ok", "busy", "bad"} {
+		assert.Nil(t, l.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: msg, When: when}))
+	}
+	l.Flush()
+
+	assert.Equal(t, BulkStats{Indexed: 2, Failed: 1, Retried: 2}, l.(*esLogger).Stats())
+	assert.Equal(t, []string{"2021.03.04 ok", "2021.03.04 busy"}, f.indexed)
+	assert.Equal(t, 3, f.requests)
+	l.Destroy()
+}
+
+func TestBulkIndexerBatching(t *testing.T) {
+	f, dsn := newFakeBulk(t, nil)
+
+	l := NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","batchSize":2,"batchWait":10000}`, dsn)))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, l.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: "m", When: time.Now()}))
+	}
+	l.Destroy()
+
+	assert.Equal(t, 3, f.requests)
+	assert.Equal(t, 5, len(f.indexed))
+}
+
+func TestBulkIndexerGivesUp(t *testing.T) {
+	f, dsn := newFakeBulk(t, func(map[string]interface{}, int) int { return 503 })
+
+	l := NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","retries":2,"retryMin":1,"retryMax":1}`, dsn)))
+	assert.Nil(t, l.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: "down", When: time.Now()}))
+	l.Flush()
+
+	assert.Equal(t, BulkStats{Failed: 1, Retried: 2}, l.(*esLogger).Stats())
+	assert.Equal(t, 3, f.attempts["down"])
+	l.Destroy()
+}
+
+func TestStats(t *testing.T) {
+	_, dsn := newFakeBulk(t, nil)
+
+	bl := logs.NewLogger()
+	_, ok := Stats(bl)
+	assert.False(t, ok)
+	assert.Nil(t, bl.SetLogger(logs.AdapterEs, fmt.Sprintf(`{"dsn":"%s"}`, dsn)))
+	bl.Error("indexed")
+	bl.Flush()
+	stats, ok := Stats(bl)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), stats.Indexed)
+	bl.Close()
+}
@@ -21,16 +21,16 @@ package es
 // THE SOFTWARE.
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
-	"strings"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v6"
-	"github.com/elastic/go-elasticsearch/v6/esapi"
 
 	logs "github.com/bhojpur/logger/pkg/engine"
 )
@@ -38,9 +38,14 @@ import (
 // NewES returns a LoggerInterface
 func NewES() logs.Logger {
 	cw := &esLogger{
-		Level:       logs.LevelDebug,
-		indexNaming: indexNaming,
+		Level:        logs.LevelDebug,
+		Timeout:      10000,
+		BatchOptions: defaultBatchOptions(),
+		TemplateName: "bhojpur-logs",
+		pid:          os.Getpid(),
 	}
+	cw.hostname, _ = os.Hostname()
+	cw.formatter = cw
 	return cw
 }
 
@@ -52,53 +57,164 @@ func NewES() logs.Logger {
 // import _ "github.com/bhojpur/logger/pkg/engine/es"
 type esLogger struct {
 	*elasticsearch.Client
-	DSN       string `json:"dsn"`
-	Level     int    `json:"level"`
+	DSN       string   `json:"dsn"`
+	Addresses []string `json:"addresses"` // more nodes besides dsn
+	Level     int      `json:"level"`
 	formatter logs.LogFormatter
 	Formatter string `json:"formatter"`
+	Timeout   int    `json:"timeout"` // milliseconds per request
+	Index     string `json:"index"`   // index pattern, see Init
 
+	Username string `json:"username"`
+	Password string `json:"password"`
+	APIKey   string `json:"apiKey"` // base64 of id:key
+	Bearer   string `json:"bearer"`
+	logs.TLSOptions
+
+	Version          int      `json:"version"` // major version, read from the cluster when 0
+	DataStream       string   `json:"dataStream"`
+	Template         bool     `json:"template"`
+	TemplateName     string   `json:"templateName"`
+	TemplatePatterns []string `json:"templatePatterns"`
+	ILMDeleteAfter   string   `json:"ilmDeleteAfter"`
+	logs.BatchOptions
+
+	minor       int
+	openSearch  bool
+	hostname    string
+	pid         int
+	lock        sync.RWMutex // guards indexNaming and hook, set while logging
 	indexNaming IndexNaming
+	hook        DocumentHook
+	indexer     *bulkIndexer
 }
 
+// Format returns the LogDocument of lm as JSON.
 func (el *esLogger) Format(lm *logs.LogMsg) string {
+	doc := el.document(lm)
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return doc.Msg
+	}
+	return string(body)
+}
 
-	msg := lm.OldStyleFormat()
-	idx := LogDocument{
+// document returns the LogDocument of lm.
+func (el *esLogger) document(lm *logs.LogMsg) *LogDocument {
+	doc := &LogDocument{
+		Time:      lm.When.Format(time.RFC3339Nano),
 		Timestamp: lm.When.Format(time.RFC3339),
-		Msg:       msg,
+		Msg:       lm.Message(),
+		Level:     logs.LevelName(lm.Level),
+		File:      lm.FilePath,
+		Line:      lm.LineNumber,
+		Prefix:    lm.Prefix,
+		Hostname:  el.hostname,
+		Pid:       el.pid,
 	}
-	body, err := json.Marshal(idx)
-	if err != nil {
-		return msg
+	if len(lm.Fields) > 0 {
+		doc.Fields = make(map[string]interface{}, len(lm.Fields))
+		for k, v := range lm.Fields {
+			doc.Fields[k] = v
+		}
 	}
-	return string(body)
+	return doc
 }
 
 func (el *esLogger) SetFormatter(f logs.LogFormatter) {
 	el.formatter = f
 }
 
-// {"dsn":"http://localhost:9200/","level":1}
+// Init the es adapter. Documents are sent through the _bulk API in
+// batches of batchSize documents or batchBytes bytes, at least every
+// batchWait milliseconds. Documents rejected with 429 or 5xx are retried:
+//
+//	{"dsn":"http://localhost:9200/","level":1,"batchSize":500,"batchWait":1000,"queueSize":10000,"retries":3}
+//
+// Elasticsearch 6, 7 and 8 and OpenSearch are supported; documents carry
+// a _type on Elasticsearch 6 only. The version is read from the cluster
+// unless set. Requests are spread over dsn and addresses, authenticated
+// with username and password, apiKey or bearer, and ca, cert, key,
+// servername and insecureSkipVerify set up TLS. With dataStream set,
+// documents are appended to that data stream instead of the daily
+// indices, from Elasticsearch 7.9. With template on, an index template
+// named templateName is put at start for templatePatterns, or the data
+// stream, along with an ILM policy deleting indices after ilmDeleteAfter
+// when set.
+//
+// index names the indices after a pattern, where {level}, {prefix} and
+// {hostname} are replaced by those of the message, and other placeholders
+// are time layouts formatting its time. A pattern without a time
+// placeholder is a layout itself, as "shop-{level}-2006.01.02". Without
+// it the IndexNaming set by SetIndexNaming is used:
+//
+//	{
+//	"addresses":["https://es1:9200","https://es2:9200"],
+//	"apiKey":"VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw==",
+//	"ca":"/etc/ssl/es-ca.pem",
+//	"dataStream":"logs-shop-default",
+//	"template":true,
+//	"ilmDeleteAfter":"30d"
+//	}
+//
+//	{"dsn":"http://localhost:9200/","index":"shop-{level}-2006.01.02"}
 func (el *esLogger) Init(config string) error {
 
 	err := json.Unmarshal([]byte(config), el)
 	if err != nil {
 		return err
 	}
-	if el.DSN == "" {
+	addresses := el.Addresses
+	if el.DSN != "" {
+		if u, err := url.Parse(el.DSN); err != nil {
+			return err
+		} else if u.Path == "" {
+			return errors.New("missing prefix")
+		}
+		addresses = append([]string{el.DSN}, addresses...)
+	}
+	if len(addresses) == 0 {
 		return errors.New("empty dsn")
-	} else if u, err := url.Parse(el.DSN); err != nil {
+	}
+	tlsConfig, err := el.ClientConfig("")
+	if err != nil {
 		return err
-	} else if u.Path == "" {
-		return errors.New("missing prefix")
-	} else {
-		conn, err := elasticsearch.NewClient(elasticsearch.Config{
-			Addresses: []string{el.DSN},
-		})
-		if err != nil {
-			return err
+	}
+	cfg := elasticsearch.Config{
+		Addresses: addresses,
+		Username:  el.Username,
+		Password:  el.Password,
+		APIKey:    el.APIKey,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	if el.Bearer != "" {
+		cfg.Header = http.Header{"Authorization": {"Bearer " + el.Bearer}}
+	}
+	if el.Client, err = elasticsearch.NewClient(cfg); err != nil {
+		return err
+	}
+
+	if el.Index != "" {
+		el.lock.Lock()
+		el.indexNaming = &patternIndexNaming{pattern: el.Index, hostname: el.hostname}
+		el.lock.Unlock()
+	}
+
+	if el.Version == 0 {
+		if err := el.detect(); err != nil {
+			fmt.Fprintf(os.Stderr, "es: reading the cluster version: %s, assuming a typeless cluster\n", err)
+		}
+	}
+	if el.DataStream != "" && !el.dataStreams() {
+		return errors.New(fmt.Sprintf("dataStream needs Elasticsearch 7.9 or later, the cluster is %d.%d", el.Version, el.minor))
+	}
+	if el.Template {
+		if err := el.installTemplate(); err != nil {
+			fmt.Fprintf(os.Stderr, "es: installing the index template: %s\n", err)
 		}
-		el.Client = conn
 	}
 	if len(el.Formatter) > 0 {
 		fmtr, ok := logs.GetFormatter(el.Formatter)
@@ -107,38 +223,131 @@ func (el *esLogger) Init(config string) error {
 		}
 		el.formatter = fmtr
 	}
+	el.Destroy()
+	op := "index"
+	if el.DataStream != "" {
+		op = "create"
+	}
+	el.indexer = newBulkIndexer(el.Client, el.BatchOptions, time.Duration(el.Timeout)*time.Millisecond, op, !el.typeless())
 	return nil
 }
 
-// WriteMsg writes the msg and level into es
+// WriteMsg queues the msg for the next bulk request.
 func (el *esLogger) WriteMsg(lm *logs.LogMsg) error {
 	if lm.Level > el.Level {
 		return nil
 	}
+	el.lock.RLock()
+	naming, hook := el.indexNaming, el.hook
+	el.lock.RUnlock()
+	if naming == nil {
+		naming = globalIndexNaming()
+	}
+	index := el.DataStream
+	if index == "" {
+		index = naming.IndexName(lm)
+	}
+	if el.formatter != logs.LogFormatter(el) {
+		el.indexer.add(lm, index, []byte(el.formatter.Format(lm)))
+		return nil
+	}
 
-	msg := el.formatter.Format(lm)
+	doc := el.document(lm)
+	if hook != nil {
+		index = hook(lm, doc, index)
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	el.indexer.add(lm, index, body)
+	return nil
+}
 
-	req := esapi.IndexRequest{
-		Index:        indexNaming.IndexName(lm),
-		DocumentType: "logs",
-		Body:         strings.NewReader(msg),
+// Stats returns the indexing counters.
+func (el *esLogger) Stats() BulkStats {
+	if el.indexer == nil {
+		return BulkStats{}
 	}
-	_, err := req.Do(context.Background(), el.Client)
-	return err
+	return el.indexer.Stats()
 }
 
-// Destroy is a empty method
+// Destroy sends the queued documents and stops the adapter.
 func (el *esLogger) Destroy() {
+	if el.indexer != nil {
+		el.indexer.close()
+		el.indexer = nil
+	}
 }
 
-// Flush is a empty method
+// Flush blocks until the queued documents are indexed or given up.
 func (el *esLogger) Flush() {
-
+	if el.indexer != nil {
+		el.indexer.Flush()
+	}
 }
 
+// LogDocument is the document indexed for a message. @timestamp is the
+// field data streams require; timestamp is kept for existing indices.
+// Fields holds the structured fields of the message, see LogFields.
 type LogDocument struct {
-	Timestamp string `json:"timestamp"`
-	Msg       string `json:"msg"`
+	Time      string                 `json:"@timestamp"`
+	Timestamp string                 `json:"timestamp"`
+	Msg       string                 `json:"msg"`
+	Level     string                 `json:"level,omitempty"`
+	File      string                 `json:"file,omitempty"`
+	Line      int                    `json:"line,omitempty"`
+	Prefix    string                 `json:"prefix,omitempty"`
+	Hostname  string                 `json:"hostname,omitempty"`
+	Pid       int                    `json:"pid,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// DocumentHook is called with every message, its document and the index
+// picked for it before the document is queued. It may change the
+// document and returns the index to write to, so one logger can route
+// documents to indices of its own:
+//
+//	es.SetDocumentHook(log, func(lm *logs.LogMsg, doc *es.LogDocument, index string) string {
+//		doc.Fields["service"] = "shop"
+//		if lm.Level <= logs.LevelError {
+//			return "shop-errors"
+//		}
+//		return index
+//	})
+//
+// The hook is not called when the adapter has a formatter.
+type DocumentHook func(lm *logs.LogMsg, doc *LogDocument, index string) string
+
+// SetDocumentHook sets the DocumentHook of the es adapter of bl. It
+// returns false when bl has no es adapter.
+func SetDocumentHook(bl *logs.BhojpurLogger, hook DocumentHook) bool {
+	el, ok := adapter(bl)
+	if ok {
+		el.lock.Lock()
+		el.hook = hook
+		el.lock.Unlock()
+	}
+	return ok
+}
+
+// Stats returns the indexing counters of the es adapter of bl. The second
+// value is false when no es adapter is set.
+func Stats(bl *logs.BhojpurLogger) (BulkStats, bool) {
+	el, ok := adapter(bl)
+	if !ok {
+		return BulkStats{}, false
+	}
+	return el.Stats(), true
+}
+
+func adapter(bl *logs.BhojpurLogger) (*esLogger, bool) {
+	l, ok := bl.Adapter(logs.AdapterEs)
+	if !ok {
+		return nil, false
+	}
+	el, ok := l.(*esLogger)
+	return el, ok
 }
 
 func init() {
@@ -0,0 +1,227 @@
+package es
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	logs "github.com/bhojpur/logger/pkg/engine"
+)
+
+func TestESVersions(t *testing.T) {
+	for _, tc := range []struct {
+		version, distribution string
+		action                string
+	}{
+		{"6.8.23", "", `{"index":{"_index":"2021.03.04","_type":"logs"}}`},
+		{"7.17.9", "", `{"index":{"_index":"2021.03.04"}}`},
+		{"8.5.0", "", `{"index":{"_index":"2021.03.04"}}`},
+		{"2.11.0", "opensearch", `{"index":{"_index":"2021.03.04"}}`},
+	} {
+		f, dsn := newFakeBulk(t, nil)
+		f.version, f.distribution = tc.version, tc.distribution
+
+		l := NewES()
+		assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s"}`, dsn)))
+		assert.Nil(t, l.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: "m", When: time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)}))
+		l.Destroy()
+		assert.Equal(t, []string{tc.action}, f.actions, tc.version)
+	}
+}
+
+func TestESAuth(t *testing.T) {
+	for config, auth := range map[string]string{
+		`"username":"elastic","password":"pw"`: "Basic " + base64.StdEncoding.EncodeToString([]byte("elastic:pw")),
+		`"apiKey":"aWQ6a2V5"`:                  "APIKey aWQ6a2V5",
+		`"bearer":"token"`:                     "Bearer token",
+	} {
+		f, dsn := newFakeBulk(t, nil)
+		l := NewES()
+		assert.Nil(t, l.Init(fmt.Sprintf(`{"addresses":["%s"],%s}`, dsn, config)))
+		assert.Nil(t, l.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: "m", When: time.Now()}))
+		l.Destroy()
+		assert.Equal(t, []string{auth, auth}, f.auth, config)
+	}
+}
+
+func TestESDataStream(t *testing.T) {
+	f, dsn := newFakeBulk(t, nil)
+	l := NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","dataStream":"logs-shop-default","template":true,"ilmDeleteAfter":"30d"}`, dsn)))
+	assert.Nil(t, l.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: "m", When: time.Now()}))
+	l.Destroy()
+
+	assert.Equal(t, []string{`{"create":{"_index":"logs-shop-default"}}`}, f.actions)
+
+	var policy, tmpl map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(f.others["PUT /_ilm/policy/bhojpur-logs"]), &policy))
+	assert.Equal(t, "30d", policy["policy"].(map[string]interface{})["phases"].(map[string]interface{})["delete"].(map[string]interface{})["min_age"])
+	assert.Nil(t, json.Unmarshal([]byte(f.others["PUT /_index_template/bhojpur-logs"]), &tmpl))
+	assert.Equal(t, []interface{}{"logs-shop-default"}, tmpl["index_patterns"])
+	assert.Equal(t, map[string]interface{}{}, tmpl["data_stream"])
+	assert.Equal(t, map[string]interface{}{"index.lifecycle.name": "bhojpur-logs"}, tmpl["template"].(map[string]interface{})["settings"])
+}
+
+func TestESDataStreamVersion(t *testing.T) {
+	f, dsn := newFakeBulk(t, nil)
+	f.version = "7.8.1"
+	l := NewES()
+	assert.NotNil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","dataStream":"logs-shop-default","template":true}`, dsn)))
+	assert.Empty(t, f.others)
+
+	// 7.8 has composable templates, without data_stream
+	f, dsn = newFakeBulk(t, nil)
+	f.version = "7.8.1"
+	l = NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","template":true,"templatePatterns":["20*"]}`, dsn)))
+	l.Destroy()
+	var tmpl map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(f.others["PUT /_index_template/bhojpur-logs"]), &tmpl))
+	assert.NotContains(t, tmpl, "data_stream")
+}
+
+func TestESLegacyTemplate(t *testing.T) {
+	f, dsn := newFakeBulk(t, nil)
+	f.version = "7.4.2"
+	l := NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","template":true,"templateName":"shop","templatePatterns":["20*"]}`, dsn)))
+	l.Destroy()
+
+	var tmpl map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(f.others["PUT /_template/shop"]), &tmpl))
+	assert.Equal(t, []interface{}{"20*"}, tmpl["index_patterns"])
+	assert.Contains(t, tmpl["mappings"], "properties")
+
+	// OpenSearch has no ILM, the template is left alone
+	f, dsn = newFakeBulk(t, nil)
+	f.version, f.distribution = "2.11.0", "opensearch"
+	l = NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","template":true,"templatePatterns":["20*"],"ilmDeleteAfter":"30d"}`, dsn)))
+	l.Destroy()
+	assert.Empty(t, f.others)
+}
+
+func TestESMultipleNodes(t *testing.T) {
+	f1, dsn1 := newFakeBulk(t, nil)
+	f2 := &fakeBulk{version: "8.5.0", attempts: map[string]int{}, others: map[string]string{}}
+	srv := httptest.NewServer(f2)
+	defer srv.Close()
+
+	l := NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","addresses":["%s"],"version":8,"batchSize":1}`, dsn1, srv.URL)))
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, l.WriteMsg(&logs.LogMsg{Level: logs.LevelError, Msg: "m", When: time.Now()}))
+	}
+	l.Destroy()
+	assert.NotZero(t, f1.requests)
+	assert.NotZero(t, f2.requests)
+	assert.Equal(t, 4, f1.requests+f2.requests)
+}
+
+func TestESDocument(t *testing.T) {
+	f, dsn := newFakeBulk(t, nil)
+	l := NewES()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"dsn":"%s","index":"shop-{level}-{2006.01.02}"}`, dsn)))
+	when := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, l.WriteMsg(&logs.LogMsg{
+		Level:      logs.LevelError,
+		Msg:        "order %d failed",
+		Args:       []interface{}{7},
+		When:       when,
+		FilePath:   "/src/order.go",
+		LineNumber: 12,
+		Prefix:     "api",
+		Fields:     map[string]interface{}{"order": 7},
+	}))
+	l.Destroy()
+
+	el := l.(*esLogger)
+	assert.Equal(t, []string{`{"index":{"_index":"shop-error-2021.03.04"}}`}, f.actions)
+	assert.Equal(t, []map[string]interface{}{{
+		"@timestamp": "2021-03-04T10:00:00Z",
+		"timestamp":  "2021-03-04T10:00:00Z",
+		"msg":        "order 7 failed",
+		"level":      "error",
+		"file":       "/src/order.go",
+		"line":       float64(12),
+		"prefix":     "api",
+		"hostname":   el.hostname,
+		"pid":        float64(el.pid),
+		"fields":     map[string]interface{}{"order": float64(7)},
+	}}, f.docs)
+}
+
+type prefixIndexNaming struct{}
+
+func (prefixIndexNaming) IndexName(lm *logs.LogMsg) string {
+	return "by-" + lm.Prefix
+}
+
+func TestESDocumentHook(t *testing.T) {
+	f, dsn := newFakeBulk(t, nil)
+	bl := logs.NewLogger()
+	assert.False(t, SetDocumentHook(bl, nil))
+	assert.Nil(t, bl.SetLogger(logs.AdapterEs, fmt.Sprintf(`{"dsn":"%s"}`, dsn)))
+	assert.True(t, SetLoggerIndexNaming(bl, prefixIndexNaming{}))
+	assert.True(t, SetDocumentHook(bl, func(lm *logs.LogMsg, doc *LogDocument, index string) string {
+		doc.Fields = map[string]interface{}{"service": "shop"}
+		if lm.Level <= logs.LevelError {
+			return "shop-errors"
+		}
+		return index
+	}))
+	bl.SetPrefix("api")
+	bl.Error("failed")
+	bl.Info("done")
+	bl.Close()
+
+	assert.Equal(t, []string{"shop-errors failed", "by-api done"}, f.indexed)
+	assert.Equal(t, map[string]interface{}{"service": "shop"}, f.docs[0]["fields"])
+}
+
+func TestESSetHookWhileLogging(t *testing.T) {
+	_, dsn := newFakeBulk(t, nil)
+	bl := logs.NewLogger()
+	assert.Nil(t, bl.SetLogger(logs.AdapterEs, fmt.Sprintf(`{"dsn":"%s"}`, dsn)))
+	defer bl.Close()
+
+	// the hook and index naming may change while messages are written
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			bl.Info("message %d", i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		SetDocumentHook(bl, func(lm *logs.LogMsg, doc *LogDocument, index string) string { return index })
+		SetLoggerIndexNaming(bl, prefixIndexNaming{})
+		SetIndexNaming(&defaultIndexNaming{})
+	}
+	<-done
+}
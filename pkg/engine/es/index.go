@@ -22,6 +22,10 @@ package es
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	logs "github.com/bhojpur/logger/pkg/engine"
 )
@@ -31,15 +35,107 @@ type IndexNaming interface {
 	IndexName(lm *logs.LogMsg) string
 }
 
-var indexNaming IndexNaming = &defaultIndexNaming{}
+var (
+	indexNamingLock sync.RWMutex
+	indexNaming     IndexNaming = &defaultIndexNaming{}
+)
 
-// SetIndexNaming will register global IndexNaming
+// SetIndexNaming will register global IndexNaming, used by the es
+// adapters without an index of their own
 func SetIndexNaming(i IndexNaming) {
+	indexNamingLock.Lock()
+	defer indexNamingLock.Unlock()
 	indexNaming = i
 }
 
+func globalIndexNaming() IndexNaming {
+	indexNamingLock.RLock()
+	defer indexNamingLock.RUnlock()
+	return indexNaming
+}
+
+// SetLoggerIndexNaming sets the IndexNaming of the es adapter of bl,
+// over the index of its config and the global one. It returns false when
+// bl has no es adapter.
+func SetLoggerIndexNaming(bl *logs.BhojpurLogger, i IndexNaming) bool {
+	el, ok := adapter(bl)
+	if ok {
+		el.lock.Lock()
+		el.indexNaming = i
+		el.lock.Unlock()
+	}
+	return ok
+}
+
 type defaultIndexNaming struct{}
 
 func (d *defaultIndexNaming) IndexName(lm *logs.LogMsg) string {
 	return fmt.Sprintf("%04d.%02d.%02d", lm.When.Year(), lm.When.Month(), lm.When.Day())
 }
+
+// patternIndexNaming names indices after a pattern from the config, see
+// expandIndexPattern.
+type patternIndexNaming struct {
+	pattern  string
+	hostname string
+}
+
+func (p *patternIndexNaming) IndexName(lm *logs.LogMsg) string {
+	return expandIndexPattern(p.pattern, lm.When, func(name string) string {
+		switch name {
+		case "level":
+			return logs.LevelName(lm.Level)
+		case "prefix":
+			return strings.Trim(lm.Prefix, " []")
+		}
+		return p.hostname
+	})
+}
+
+var indexPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// expandIndexPattern returns the index name of pattern, where {level},
+// {prefix} and {hostname} are replaced by value of their name, and any
+// other placeholder is a time layout formatting t, as in
+// "shop-{level}-{2006.01.02}". The rest of pattern is kept as is, unless
+// pattern has no time placeholder: then the rest is the layout, as in
+// "shop-{level}-2006.01.02", so names with digits or month and day names
+// need the layout in braces. Index names are lowercase.
+func expandIndexPattern(pattern string, t time.Time, value func(name string) string) string {
+	bare := len(indexTimeLayouts(pattern)) == 0
+	var b strings.Builder
+	last := 0
+	for _, loc := range indexPlaceholder.FindAllStringIndex(pattern, -1) {
+		b.WriteString(expandIndexLiteral(pattern[last:loc[0]], t, bare))
+		switch ph := pattern[loc[0]+1 : loc[1]-1]; ph {
+		case "level", "prefix", "hostname":
+			b.WriteString(value(ph))
+		default:
+			b.WriteString(t.Format(ph))
+		}
+		last = loc[1]
+	}
+	b.WriteString(expandIndexLiteral(pattern[last:], t, bare))
+	return strings.ToLower(b.String())
+}
+
+func expandIndexLiteral(s string, t time.Time, layout bool) string {
+	if layout && s != "" {
+		return t.Format(s)
+	}
+	return s
+}
+
+// indexTimeLayouts returns the time placeholders of pattern, without
+// their braces.
+func indexTimeLayouts(pattern string) []string {
+	var layouts []string
+	for _, ph := range indexPlaceholder.FindAllString(pattern, -1) {
+		switch ph = ph[1 : len(ph)-1]; ph {
+		case "level", "prefix", "hostname":
+		default:
+			layouts = append(layouts, ph)
+		}
+	}
+	return layouts
+}
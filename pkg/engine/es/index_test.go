@@ -38,3 +38,24 @@ func TestDefaultIndexNaming_IndexName(t *testing.T) {
 	res := (&defaultIndexNaming{}).IndexName(lm)
 	assert.Equal(t, "2018.03.26", res)
 }
+
+func TestPatternIndexNaming_IndexName(t *testing.T) {
+	lm := &logs.LogMsg{
+		Level:  logs.LevelWarning,
+		Prefix: "[API]",
+		When:   time.Date(2018, 3, 26, 1, 34, 45, 234, time.UTC),
+	}
+
+	res := (&patternIndexNaming{pattern: "shop-{level}-{prefix}-{2006.01.02}", hostname: "web1"}).IndexName(lm)
+	assert.Equal(t, "shop-warning-api-2018.03.26", res)
+	res = (&patternIndexNaming{pattern: "{hostname}-{2006.01}", hostname: "Web1"}).IndexName(lm)
+	assert.Equal(t, "web1-2018.03", res)
+	// only the placeholders are time layouts
+	res = (&patternIndexNaming{pattern: "app-v1-pm-jan-mon-{2006.01.02}"}).IndexName(lm)
+	assert.Equal(t, "app-v1-pm-jan-mon-2018.03.26", res)
+	// without a time placeholder, the rest of the pattern is the layout
+	res = (&patternIndexNaming{pattern: "shop-{level}-2006.01.02"}).IndexName(lm)
+	assert.Equal(t, "shop-warning-2018.03.26", res)
+	res = (&patternIndexNaming{pattern: "{prefix}"}).IndexName(lm)
+	assert.Equal(t, "api", res)
+}
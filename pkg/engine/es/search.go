@@ -0,0 +1,432 @@
+package es
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6"
+
+	logs "github.com/bhojpur/logger/pkg/engine"
+)
+
+// Query is a search over the log documents, parsed by ParseQuery.
+type Query struct {
+	Since time.Time
+	Until time.Time // zero for now
+	terms []string  // words of msg
+	exact []string  // quoted phrases of msg
+	match map[string]string
+	level []string
+}
+
+// queryFields are the LogDocument fields taken by name in a query; other
+// names are looked up in the structured fields.
+var queryFields = map[string]bool{"msg": true, "prefix": true, "file": true, "line": true, "hostname": true, "pid": true}
+
+// ParseQuery parses a query of space separated terms:
+//
+//	text, "quoted phrase"   words or phrases of the message
+//	level>=error            levels by name, with =, <, <=, > or >=,
+//	                        where error > warning
+//	prefix=api              the prefix, like msg, file, line, hostname
+//	                        and pid
+//	order=7                 a structured field
+//	since=1h, until=30m     a time range, as a duration back from now
+//	                        or an RFC 3339 time
+//
+// Without since the last 24 hours are searched.
+func ParseQuery(s string, now time.Time) (*Query, error) {
+	q := &Query{Since: now.Add(-24 * time.Hour), match: map[string]string{}}
+	tokens, err := queryTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, tok := range tokens {
+		if tok.quoted {
+			q.exact = append(q.exact, tok.text)
+			continue
+		}
+		i := strings.IndexAny(tok.text, "=<>")
+		if i <= 0 {
+			q.terms = append(q.terms, tok.text)
+			continue
+		}
+		key, op, value := tok.text[:i], tok.text[i:i+1], tok.text[i+1:]
+		if strings.HasPrefix(value, "=") && op != "=" {
+			op, value = op+"=", value[1:]
+		}
+		switch {
+		case key == "level":
+			if q.level, err = queryLevels(op, value); err != nil {
+				return nil, err
+			}
+		case op != "=":
+			return nil, fmt.Errorf("%s: only level takes %s", tok.text, op)
+		case key == "since" || key == "until":
+			t, err := queryTime(value, now)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", tok.text, err)
+			}
+			if key == "since" {
+				q.Since = t
+			} else {
+				q.Until = t
+			}
+		default:
+			q.match[key] = value
+		}
+	}
+	return q, nil
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+func queryTokens(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return tokens, nil
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %s", s)
+			}
+			tokens = append(tokens, queryToken{text: s[1 : end+1], quoted: true})
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		tokens = append(tokens, queryToken{text: s[:end]})
+		s = s[end:]
+	}
+}
+
+// queryLevels returns the names of the levels matching op name. A more
+// severe level, with a lower number, is greater.
+func queryLevels(op, name string) ([]string, error) {
+	level := -1
+	for i := logs.LevelEmergency; i <= logs.LevelDebug; i++ {
+		if logs.LevelName(i) == strings.ToLower(name) {
+			level = i
+		}
+	}
+	if level < 0 {
+		return nil, fmt.Errorf("unknown level: %s", name)
+	}
+	var names []string
+	for i := logs.LevelEmergency; i <= logs.LevelDebug; i++ {
+		var ok bool
+		switch op {
+		case "=":
+			ok = i == level
+		case ">":
+			ok = i < level
+		case ">=":
+			ok = i <= level
+		case "<":
+			ok = i > level
+		case "<=":
+			ok = i >= level
+		}
+		if ok {
+			names = append(names, logs.LevelName(i))
+		}
+	}
+	return names, nil
+}
+
+func queryTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Body returns the search request body for the newest size documents,
+// or for the documents from after on, oldest first, when after is set.
+func (q *Query) Body(size int, after time.Time) map[string]interface{} {
+	timeRange := map[string]interface{}{"gte": q.Since.Format(time.RFC3339Nano)}
+	if !after.IsZero() {
+		timeRange["gte"] = after.Format(time.RFC3339Nano)
+	}
+	if !q.Until.IsZero() {
+		timeRange["lte"] = q.Until.Format(time.RFC3339Nano)
+	}
+	// documents indexed before @timestamp was added only have timestamp
+	filter := []interface{}{
+		map[string]interface{}{"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"range": map[string]interface{}{"@timestamp": timeRange}},
+				map[string]interface{}{"range": map[string]interface{}{"timestamp": timeRange}},
+			},
+			"minimum_should_match": 1,
+		}},
+	}
+	if q.level != nil {
+		filter = append(filter, map[string]interface{}{"terms": map[string]interface{}{"level": q.level}})
+	}
+	var keys []string
+	for key := range q.match {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := key
+		if !queryFields[key] {
+			field = "fields." + key
+		}
+		filter = append(filter, map[string]interface{}{"match": map[string]interface{}{
+			field: map[string]interface{}{"query": q.match[key], "operator": "and"},
+		}})
+	}
+
+	var must []interface{}
+	if len(q.terms) > 0 {
+		must = append(must, map[string]interface{}{"match": map[string]interface{}{
+			"msg": map[string]interface{}{"query": strings.Join(q.terms, " "), "operator": "and"},
+		}})
+	}
+	for _, phrase := range q.exact {
+		must = append(must, map[string]interface{}{"match_phrase": map[string]interface{}{"msg": phrase}})
+	}
+	boolQuery := map[string]interface{}{"filter": filter}
+	if must != nil {
+		boolQuery["must"] = must
+	}
+
+	// the documents without @timestamp are older than those with it
+	order, missing := "desc", "_last"
+	if !after.IsZero() {
+		order, missing = "asc", "_first"
+	}
+	return map[string]interface{}{
+		"query": map[string]interface{}{"bool": boolQuery},
+		"sort": []interface{}{
+			map[string]interface{}{"@timestamp": map[string]string{"order": order, "missing": missing, "unmapped_type": "date"}},
+			map[string]interface{}{"timestamp": map[string]string{"order": order, "unmapped_type": "date"}},
+		},
+		"size": size,
+	}
+}
+
+// maxSearchIndices is the most indices a search is sent to, as they all
+// go in the request path.
+const maxSearchIndices = 1000
+
+// Indices returns the indices named by pattern, an index pattern as in
+// the es adapter config, for the time from since to until, down to the
+// hour. Placeholders match any value. It fails when the range needs more
+// than maxSearchIndices indices.
+func Indices(pattern string, since, until time.Time) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	add := func(t time.Time) {
+		name := expandIndexPattern(pattern, t, func(string) string { return "*" })
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for t := since; t.Before(until) && len(names) <= maxSearchIndices; t = t.Add(time.Hour) {
+		add(t)
+	}
+	add(until)
+	if len(names) > maxSearchIndices {
+		return nil, fmt.Errorf("%s names more than %d indices from %s to %s, narrow the time range",
+			pattern, maxSearchIndices, since.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+	return names, nil
+}
+
+// Hit is a document found by a Searcher.
+type Hit struct {
+	ID    string
+	Index string
+	Doc   LogDocument
+}
+
+// When returns the time of the document.
+func (h *Hit) When() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, h.Doc.Time)
+	if t.IsZero() {
+		t, _ = time.Parse(time.RFC3339, h.Doc.Timestamp)
+	}
+	return t
+}
+
+// LogMsg returns the document as a message, for the adapters to print.
+func (h *Hit) LogMsg() *logs.LogMsg {
+	lm := &logs.LogMsg{
+		Level:      logs.LevelDebug,
+		Msg:        h.Doc.Msg,
+		When:       h.When().Local(),
+		FilePath:   h.Doc.File,
+		LineNumber: h.Doc.Line,
+		Prefix:     h.Doc.Prefix,
+		Fields:     h.Doc.Fields,
+	}
+	for i := logs.LevelEmergency; i <= logs.LevelDebug; i++ {
+		if logs.LevelName(i) == h.Doc.Level {
+			lm.Level = i
+		}
+	}
+	return lm
+}
+
+// Searcher runs queries against the indices of an index pattern.
+type Searcher struct {
+	client  *elasticsearch.Client
+	pattern string
+	now     func() time.Time
+}
+
+// NewSearcher returns a Searcher for the cluster at dsn, which may carry
+// basic auth credentials. pattern is an index pattern as in the es
+// adapter config, "{2006.01.02}" for the default IndexNaming.
+func NewSearcher(dsn, apiKey, pattern string) (*Searcher, error) {
+	if _, err := url.Parse(dsn); err != nil {
+		return nil, err
+	}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{dsn}, APIKey: apiKey})
+	if err != nil {
+		return nil, err
+	}
+	return &Searcher{client: client, pattern: pattern, now: time.Now}, nil
+}
+
+// Search returns the newest size documents matching q, oldest first.
+func (s *Searcher) Search(ctx context.Context, q *Query, size int) ([]Hit, error) {
+	hits, err := s.search(ctx, q, q.Since, q.Body(size, time.Time{}))
+	for i, j := 0, len(hits)-1; i < j; i, j = i+1, j-1 {
+		hits[i], hits[j] = hits[j], hits[i]
+	}
+	return hits, err
+}
+
+// Follow calls fn with the documents matching q as they are indexed,
+// polling every interval until ctx is done. It starts after the newest
+// of shown, the documents returned by Search, or now without any.
+func (s *Searcher) Follow(ctx context.Context, q *Query, shown []Hit, interval time.Duration, fn func(Hit)) error {
+	after := s.now()
+	seen := map[string]bool{} // ids of the documents at after
+	if len(shown) > 0 {
+		after = shown[len(shown)-1].When()
+		for _, h := range shown {
+			if h.When().Equal(after) {
+				seen[h.ID] = true
+			}
+		}
+	}
+	for {
+		hits, err := s.search(ctx, q, after, q.Body(1000, after))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, h := range hits {
+			if seen[h.ID] {
+				continue
+			}
+			if t := h.When(); t.After(after) {
+				after = t
+				seen = map[string]bool{}
+			}
+			seen[h.ID] = true
+			fn(h)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// search runs body against the indices of the time from since on.
+func (s *Searcher) search(ctx context.Context, q *Query, since time.Time, body map[string]interface{}) ([]Hit, error) {
+	until := q.Until
+	if until.IsZero() {
+		until = s.now()
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	indices, err := Indices(s.pattern, since, until)
+	if err != nil {
+		return nil, err
+	}
+	path := "/" + strings.Join(indices, ",") + "/_search?ignore_unavailable=true&allow_no_indices=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Perform(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("search: %s: %s", res.Status, bytes.TrimSpace(data))
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID     string      `json:"_id"`
+				Index  string      `json:"_index"`
+				Source LogDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	hits := make([]Hit, len(result.Hits.Hits))
+	for i, h := range result.Hits.Hits {
+		hits[i] = Hit{ID: h.ID, Index: h.Index, Doc: h.Source}
+	}
+	return hits, nil
+}
@@ -0,0 +1,225 @@
+package es
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	logs "github.com/bhojpur/logger/pkg/engine"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	q, err := ParseQuery(`level>=error prefix=api order=7 since=2h until=2021-03-04T09:30:00Z payment "card declined"`, now)
+	assert.Nil(t, err)
+
+	body, _ := json.Marshal(q.Body(50, time.Time{}))
+	assert.JSONEq(t, `{
+		"query": {"bool": {
+			"filter": [
+				{"bool": {"should": [
+					{"range": {"@timestamp": {"gte": "2021-03-04T08:00:00Z", "lte": "2021-03-04T09:30:00Z"}}},
+					{"range": {"timestamp": {"gte": "2021-03-04T08:00:00Z", "lte": "2021-03-04T09:30:00Z"}}}
+				], "minimum_should_match": 1}},
+				{"terms": {"level": ["emergency", "alert", "critical", "error"]}},
+				{"match": {"fields.order": {"query": "7", "operator": "and"}}},
+				{"match": {"prefix": {"query": "api", "operator": "and"}}}
+			],
+			"must": [
+				{"match": {"msg": {"query": "payment", "operator": "and"}}},
+				{"match_phrase": {"msg": "card declined"}}
+			]
+		}},
+		"sort": [
+			{"@timestamp": {"order": "desc", "missing": "_last", "unmapped_type": "date"}},
+			{"timestamp": {"order": "desc", "unmapped_type": "date"}}
+		],
+		"size": 50
+	}`, string(body))
+
+	q, err = ParseQuery("level<warning", now)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"notice", "info", "debug"}, q.level)
+	assert.Equal(t, now.Add(-24*time.Hour), q.Since)
+
+	for _, bad := range []string{"level>=fatal", `"open`, "since=yesterday", "prefix>api"} {
+		_, err = ParseQuery(bad, now)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestIndices(t *testing.T) {
+	since := time.Date(2021, 2, 27, 22, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 3, 2, 1, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"{2006.01.02}", []string{"2021.02.27", "2021.02.28", "2021.03.01", "2021.03.02"}},
+		{"shop-{level}-{2006.01}", []string{"shop-*-2021.02", "shop-*-2021.03"}},
+		{"shop-{level}-2006.01", []string{"shop-*-2021.02", "shop-*-2021.03"}},
+		{"logs-shop-default", []string{"logs-shop-default"}},
+	} {
+		names, err := Indices(c.pattern, since, until)
+		assert.Nil(t, err, c.pattern)
+		assert.Equal(t, c.want, names, c.pattern)
+	}
+
+	// hourly indices get one name per hour
+	names, err := Indices("{2006.01.02.15}", since, since.Add(3*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2021.02.27.22", "2021.02.27.23", "2021.02.28.00", "2021.02.28.01"}, names)
+
+	// too long a range is an error, not a partial search
+	_, err = Indices("{2006.01.02.15}", since, since.AddDate(0, 3, 0))
+	assert.NotNil(t, err)
+	names, err = Indices("{2006.01.02}", since, since.AddDate(2, 0, 0))
+	assert.Nil(t, err)
+	assert.Len(t, names, 731)
+}
+
+// fakeSearch answers searches with the documents after the gte bound of
+// the query, in the requested order. Documents without @timestamp are
+// matched on timestamp.
+type fakeSearch struct {
+	lock  sync.Mutex
+	docs  []LogDocument
+	paths []string
+}
+
+func (f *fakeSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.paths = append(f.paths, r.URL.Path)
+	data, _ := ioutil.ReadAll(r.Body)
+	var body struct {
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Bool struct {
+						Should []map[string]map[string]map[string]string `json:"should"`
+					} `json:"bool"`
+				} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+		Sort []map[string]map[string]string `json:"sort"`
+		Size int                            `json:"size"`
+	}
+	json.Unmarshal(data, &body)
+	should := body.Query.Bool.Filter[0].Bool.Should
+	gte, _ := time.Parse(time.RFC3339Nano, should[0]["range"]["@timestamp"]["gte"])
+	oldGTE, _ := time.Parse(time.RFC3339Nano, should[1]["range"]["timestamp"]["gte"])
+	var hits []interface{}
+	for i, doc := range f.docs {
+		if t, _ := time.Parse(time.RFC3339Nano, doc.Time); doc.Time != "" && t.Before(gte) {
+			continue
+		}
+		if t, _ := time.Parse(time.RFC3339, doc.Timestamp); doc.Time == "" && t.Before(oldGTE) {
+			continue
+		}
+		hits = append(hits, map[string]interface{}{"_id": string(rune('a' + i)), "_index": "i", "_source": doc})
+	}
+	if body.Sort[0]["@timestamp"]["order"] == "desc" {
+		for i, j := 0, len(hits)-1; i < j; i, j = i+1, j-1 {
+			hits[i], hits[j] = hits[j], hits[i]
+		}
+	}
+	if len(hits) > body.Size {
+		hits = hits[:body.Size]
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"hits": hits}})
+}
+
+func (f *fakeSearch) add(msg string, when time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.docs = append(f.docs, LogDocument{Time: when.Format(time.RFC3339Nano), Msg: msg, Level: "warning"})
+}
+
+func TestSearcher(t *testing.T) {
+	f := &fakeSearch{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	now := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	for i, msg := range []string{"one", "two", "three"} {
+		f.add(msg, now.Add(time.Duration(i-3)*time.Minute))
+	}
+
+	s, err := NewSearcher(srv.URL, "", "{2006.01.02}")
+	assert.Nil(t, err)
+	s.now = func() time.Time { return now }
+	q, _ := ParseQuery("", now)
+	hits, err := s.Search(context.Background(), q, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(hits))
+	assert.Equal(t, "two", hits[0].Doc.Msg)
+	assert.Equal(t, "three", hits[1].Doc.Msg)
+	assert.Equal(t, "/2021.03.03,2021.03.04/_search", f.paths[0])
+
+	lm := hits[1].LogMsg()
+	assert.Equal(t, logs.LevelWarning, lm.Level)
+	assert.True(t, lm.When.Equal(now.Add(-time.Minute)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var followed []string
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		f.add("four", now.Add(time.Minute))
+	}()
+	err = s.Follow(ctx, q, hits, 10*time.Millisecond, func(h Hit) {
+		followed = append(followed, h.Doc.Msg)
+		if h.Doc.Msg == "four" {
+			cancel()
+		}
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"four"}, followed)
+}
+
+func TestSearcherTimestamp(t *testing.T) {
+	f := &fakeSearch{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	now := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	// indexed before @timestamp was added
+	f.docs = append(f.docs, LogDocument{Timestamp: now.Add(-2 * time.Minute).Format(time.RFC3339), Msg: "old"})
+	f.add("new", now.Add(-time.Minute))
+
+	s, err := NewSearcher(srv.URL, "", "{2006.01.02}")
+	assert.Nil(t, err)
+	s.now = func() time.Time { return now }
+	q, _ := ParseQuery("", now)
+	hits, err := s.Search(context.Background(), q, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(hits)) {
+		assert.Equal(t, "old", hits[0].Doc.Msg)
+		assert.True(t, hits[0].When().Equal(now.Add(-2*time.Minute)))
+	}
+}
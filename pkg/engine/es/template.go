@@ -0,0 +1,199 @@
+package es
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logMapping is the mapping of LogDocument.
+var logMapping = map[string]interface{}{
+	"dynamic": true,
+	"properties": map[string]interface{}{
+		"@timestamp": map[string]string{"type": "date"},
+		"timestamp":  map[string]string{"type": "date"},
+		"msg":        map[string]string{"type": "text"},
+		"level":      map[string]string{"type": "keyword"},
+		"file":       map[string]string{"type": "keyword"},
+		"line":       map[string]string{"type": "integer"},
+		"prefix":     map[string]string{"type": "keyword"},
+		"hostname":   map[string]string{"type": "keyword"},
+		"pid":        map[string]string{"type": "integer"},
+		"fields":     map[string]string{"type": "object"},
+	},
+}
+
+// detect reads the distribution and version of the cluster.
+func (el *esLogger) detect() error {
+	body, err := el.perform(http.MethodGet, "/", nil)
+	if err != nil {
+		return err
+	}
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err = json.Unmarshal(body, &info); err != nil {
+		return err
+	}
+	el.openSearch = info.Version.Distribution == "opensearch"
+	parts := strings.SplitN(info.Version.Number, ".", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("unknown version: %q", info.Version.Number)
+	}
+	el.Version, _ = strconv.Atoi(parts[0])
+	el.minor, _ = strconv.Atoi(parts[1])
+	return nil
+}
+
+// typeless tells whether the cluster takes documents without _type,
+// as from Elasticsearch 7 and OpenSearch.
+func (el *esLogger) typeless() bool {
+	return el.openSearch || el.Version == 0 || el.Version >= 7
+}
+
+// composable tells whether the cluster has the _index_template API,
+// from Elasticsearch 7.8 and OpenSearch.
+func (el *esLogger) composable() bool {
+	return el.openSearch || el.Version == 0 || el.Version > 7 || el.Version == 7 && el.minor >= 8
+}
+
+// dataStreams tells whether the cluster has data streams, from
+// Elasticsearch 7.9 and OpenSearch.
+func (el *esLogger) dataStreams() bool {
+	return el.openSearch || el.Version == 0 || el.Version > 7 || el.Version == 7 && el.minor >= 9
+}
+
+// installTemplate puts the ILM policy, when ilmDeleteAfter is set, and
+// the index template of the log documents.
+func (el *esLogger) installTemplate() error {
+	if el.DataStream != "" && !el.dataStreams() {
+		return fmt.Errorf("data streams need Elasticsearch 7.9 or later")
+	}
+	patterns := el.TemplatePatterns
+	if len(patterns) == 0 {
+		if el.DataStream == "" {
+			return fmt.Errorf("templatePatterns is required without a dataStream")
+		}
+		patterns = []string{el.DataStream}
+	}
+
+	settings := map[string]interface{}{}
+	if el.ILMDeleteAfter != "" {
+		if el.openSearch {
+			return fmt.Errorf("ILM policies are not available on OpenSearch")
+		}
+		hot := map[string]interface{}{}
+		if el.DataStream != "" {
+			hot["rollover"] = map[string]string{"max_age": "1d", "max_size": "50gb"}
+		}
+		policy := map[string]interface{}{
+			"policy": map[string]interface{}{
+				"phases": map[string]interface{}{
+					"hot": map[string]interface{}{"actions": hot},
+					"delete": map[string]interface{}{
+						"min_age": el.ILMDeleteAfter,
+						"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+					},
+				},
+			},
+		}
+		if _, err := el.perform(http.MethodPut, "/_ilm/policy/"+el.TemplateName, policy); err != nil {
+			return err
+		}
+		settings["index.lifecycle.name"] = el.TemplateName
+	}
+
+	if el.composable() {
+		tmpl := map[string]interface{}{
+			"index_patterns": patterns,
+			"priority":       200,
+			"template": map[string]interface{}{
+				"settings": settings,
+				"mappings": logMapping,
+			},
+		}
+		if el.DataStream != "" {
+			tmpl["data_stream"] = map[string]interface{}{}
+		}
+		_, err := el.perform(http.MethodPut, "/_index_template/"+el.TemplateName, tmpl)
+		return err
+	}
+	var mappings interface{} = logMapping
+	if !el.typeless() {
+		mappings = map[string]interface{}{"logs": logMapping}
+	}
+	_, err := el.perform(http.MethodPut, "/_template/"+el.TemplateName, map[string]interface{}{
+		"index_patterns": patterns,
+		"order":          200,
+		"settings":       settings,
+		"mappings":       mappings,
+	})
+	return err
+}
+
+// perform sends body as JSON and returns the response body, or an error
+// for a status of 300 and more.
+func (el *esLogger) perform(method, path string, body interface{}) ([]byte, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	ctx := context.Background()
+	if el.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(el.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := el.Client.Perform(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
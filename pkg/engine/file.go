@@ -22,6 +22,7 @@ package engine
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -78,6 +79,31 @@ type fileLogWriter struct {
 
 	formatter LogFormatter
 	Formatter string `json:"formatter"`
+
+	// Coordinate rotation with other processes sharing Filename
+	// through an advisory lock on Filename + ".lock"
+	MultiProcess    bool `json:"multiprocess"`
+	lockFd          *os.File
+	lineCountOffset int64 // lines before this file offset are in maxLinesCurLines
+
+	// Seal every line with a HMAC-SHA256 chain, see file_chain.go
+	Chain      bool              `json:"chain"`
+	ChainKeys  map[string]string `json:"chainkeys"`  // hex encoded keys by key id
+	ChainKeyID string            `json:"chainkeyid"` // key id sealing new lines
+	chain      *hashChain
+
+	// Run after a file has been rotated, see RegisterRotateHook
+	RotateHooks []string `json:"rotatehooks"`
+	RotateCmd   []string `json:"rotatecmd"`
+	rotateHooks []RotateHook
+
+	// Background scheduler, see schedule
+	cancel         context.CancelFunc
+	done           chan struct{}
+	wake           chan struct{}
+	jobsLock       sync.Mutex
+	pendingRotated []rotatedFile
+	pendingCleanup bool
 }
 
 // newFileWriter creates a FileLogWriter returning as LoggerInterface.
@@ -119,7 +145,13 @@ func (w *fileLogWriter) SetFormatter(f LogFormatter) {
 //  "daily":true,
 //  "maxDays":15,
 //  "rotate":true,
-//      "perm":"0600"
+//      "perm":"0600",
+//  "multiprocess":true,
+//  "chain":true,
+//  "chainkeys":{"2022":"6b6579"},
+//  "chainkeyid":"2022",
+//  "rotatehooks":["archive"],
+//  "rotatecmd":["gzip","-9"]
 //  }
 func (w *fileLogWriter) Init(config string) error {
 
@@ -143,8 +175,45 @@ func (w *fileLogWriter) Init(config string) error {
 		}
 		w.formatter = fmtr
 	}
+
+	if w.MultiProcess {
+		if err := w.openLockFile(); err != nil {
+			return err
+		}
+	}
+
+	w.chain = nil
+	if w.Chain {
+		if w.MultiProcess {
+			return errors.New("chain and multiprocess can not be used together")
+		}
+		if w.chain, err = newHashChain(w.ChainKeys, w.ChainKeyID); err != nil {
+			return err
+		}
+	}
+
+	w.rotateHooks = nil
+	for _, name := range w.RotateHooks {
+		hook, ok := GetRotateHook(name)
+		if !ok {
+			return fmt.Errorf("the rotate hook with name: %s not found", name)
+		}
+		w.rotateHooks = append(w.rotateHooks, hook)
+	}
+	if len(w.RotateCmd) > 0 {
+		w.rotateHooks = append(w.rotateHooks, commandRotateHook(w.RotateCmd))
+	}
 	err = w.startLogger()
-	return err
+	if err != nil {
+		return err
+	}
+	if w.chain != nil {
+		if err = w.chain.resume(w); err != nil {
+			return err
+		}
+	}
+	w.startScheduler()
+	return nil
 }
 
 // start file logger. create log file and set to locker-inside file writer.
@@ -160,6 +229,30 @@ func (w *fileLogWriter) startLogger() error {
 	return w.initFd()
 }
 
+// openLockFile opens the lock file used to coordinate rotation between
+// processes and checks that locking is supported.
+func (w *fileLogWriter) openLockFile() error {
+	perm, err := strconv.ParseInt(w.Perm, 8, 64)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(path.Dir(w.Filename), os.FileMode(perm))
+	fd, err := os.OpenFile(w.Filename+".lock", os.O_RDWR|os.O_CREATE, os.FileMode(perm))
+	if err != nil {
+		return err
+	}
+	if err = lockFile(fd); err != nil {
+		fd.Close()
+		return err
+	}
+	unlockFile(fd)
+	if w.lockFd != nil {
+		w.lockFd.Close()
+	}
+	w.lockFd = fd
+	return nil
+}
+
 func (w *fileLogWriter) needRotateDaily(day int) bool {
 	return (w.MaxLines > 0 && w.maxLinesCurLines >= w.MaxLines) ||
 		(w.MaxSize > 0 && w.maxSizeCurSize >= w.MaxSize) ||
@@ -182,13 +275,20 @@ func (w *fileLogWriter) WriteMsg(lm *LogMsg) error {
 	_, d, h := formatTimeHeader(lm.When)
 
 	msg := w.formatter.Format(lm)
+	if w.MultiProcess {
+		w.Lock()
+		if err := w.syncWithDisk(); err != nil {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
+		}
+		w.Unlock()
+	}
 	if w.Rotate {
 		w.RLock()
 		if w.needRotateHourly(h) {
 			w.RUnlock()
 			w.Lock()
 			if w.needRotateHourly(h) {
-				if err := w.doRotate(lm.When); err != nil {
+				if err := w.rotate(lm.When, func() bool { return w.needRotateHourly(h) }); err != nil {
 					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
 				}
 			}
@@ -197,7 +297,7 @@ func (w *fileLogWriter) WriteMsg(lm *LogMsg) error {
 			w.RUnlock()
 			w.Lock()
 			if w.needRotateDaily(d) {
-				if err := w.doRotate(lm.When); err != nil {
+				if err := w.rotate(lm.When, func() bool { return w.needRotateDaily(d) }); err != nil {
 					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
 				}
 			}
@@ -208,8 +308,17 @@ func (w *fileLogWriter) WriteMsg(lm *LogMsg) error {
 	}
 
 	w.Lock()
+	var seq uint64
+	var mac []byte
+	if w.chain != nil {
+		msg, seq, mac = w.chain.seal(msg)
+	}
 	_, err := w.fileWriter.Write([]byte(msg))
-	if err == nil {
+	if err == nil && w.chain != nil {
+		w.chain.commit(seq, mac)
+	}
+	// in multiprocess mode the counters are refreshed from disk by syncWithDisk
+	if err == nil && !w.MultiProcess {
 		w.maxLinesCurLines++
 		w.maxSizeCurSize += len(msg)
 	}
@@ -247,57 +356,149 @@ func (w *fileLogWriter) initFd() error {
 	w.hourlyOpenTime = time.Now()
 	w.hourlyOpenDate = w.hourlyOpenTime.Hour()
 	w.maxLinesCurLines = 0
-	if w.Hourly {
-		go w.hourlyRotate(w.hourlyOpenTime)
-	} else if w.Daily {
-		go w.dailyRotate(w.dailyOpenTime)
-	}
+	w.lineCountOffset = fInfo.Size()
 	if fInfo.Size() > 0 && w.MaxLines > 0 {
-		count, err := w.lines()
+		count, end, err := w.countLines(0)
 		if err != nil {
 			return err
 		}
 		w.maxLinesCurLines = count
+		w.lineCountOffset = end
 	}
 	return nil
 }
 
-func (w *fileLogWriter) dailyRotate(openTime time.Time) {
-	y, m, d := openTime.Add(24 * time.Hour).Date()
-	nextDay := time.Date(y, m, d, 0, 0, 0, 0, openTime.Location())
-	tm := time.NewTimer(time.Duration(nextDay.UnixNano() - openTime.UnixNano() + 100))
-	<-tm.C
-	w.Lock()
-	if w.needRotateDaily(time.Now().Day()) {
-		if err := w.doRotate(time.Now()); err != nil {
-			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
+// startScheduler starts the background goroutine owned by the writer.
+// It replaces the scheduler of a previous Init.
+func (w *fileLogWriter) startScheduler() {
+	w.stopScheduler()
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	w.wake = make(chan struct{}, 1)
+	go w.schedule(ctx, w.done, w.wake)
+}
+
+// stopScheduler cancels the scheduler and waits until it has finished the
+// jobs queued so far.
+func (w *fileLogWriter) stopScheduler() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+	w.cancel = nil
+}
+
+// schedule is the only background goroutine of a fileLogWriter.
+// It rotates the file at the end of each day or hour, runs the rotate
+// hooks and deletes old logs until ctx is cancelled.
+func (w *fileLogWriter) schedule(ctx context.Context, done chan struct{}, wake <-chan struct{}) {
+	defer close(done)
+
+	var tm *time.Timer
+	var tick <-chan time.Time
+	if w.Hourly || w.Daily {
+		tm = time.NewTimer(time.Until(w.nextRotateTime(time.Now())))
+		defer tm.Stop()
+		tick = tm.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.runJobs()
+			return
+		case <-wake:
+			w.runJobs()
+		case <-tick:
+			w.scheduledRotate()
+			w.runJobs()
+			tm.Reset(time.Until(w.nextRotateTime(time.Now())))
 		}
 	}
-	w.Unlock()
 }
 
-func (w *fileLogWriter) hourlyRotate(openTime time.Time) {
-	y, m, d := openTime.Add(1 * time.Hour).Date()
-	h, _, _ := openTime.Add(1 * time.Hour).Clock()
-	nextHour := time.Date(y, m, d, h, 0, 0, 0, openTime.Location())
-	tm := time.NewTimer(time.Duration(nextHour.UnixNano() - openTime.UnixNano() + 100))
-	<-tm.C
+// nextRotateTime returns the start of the hour or day following now.
+func (w *fileLogWriter) nextRotateTime(now time.Time) time.Time {
+	y, m, d := now.Date()
+	if w.Hourly {
+		return time.Date(y, m, d, now.Hour()+1, 0, 0, 100, now.Location())
+	}
+	return time.Date(y, m, d+1, 0, 0, 0, 100, now.Location())
+}
+
+// scheduledRotate rotates the file when the hour or day it was opened in
+// is over.
+func (w *fileLogWriter) scheduledRotate() {
 	w.Lock()
-	if w.needRotateHourly(time.Now().Hour()) {
-		if err := w.doRotate(time.Now()); err != nil {
+	defer w.Unlock()
+	need := func() bool { return w.needRotateDaily(time.Now().Day()) }
+	if w.Hourly {
+		need = func() bool { return w.needRotateHourly(time.Now().Hour()) }
+	}
+	if need() {
+		if err := w.rotate(time.Now(), need); err != nil {
 			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
 		}
 	}
-	w.Unlock()
 }
 
-func (w *fileLogWriter) lines() (int, error) {
+// rotatedFile is a file waiting for its manifest and rotate hooks.
+type rotatedFile struct {
+	name     string
+	manifest *ChainManifest
+}
+
+// queueJobs hands the manifest and rotate hooks of rotated and a cleanup of
+// old logs to the scheduler without blocking the caller.
+func (w *fileLogWriter) queueJobs(rotated rotatedFile) {
+	w.jobsLock.Lock()
+	if rotated.name != "" && (rotated.manifest != nil || len(w.rotateHooks) > 0) {
+		w.pendingRotated = append(w.pendingRotated, rotated)
+	}
+	w.pendingCleanup = true
+	w.jobsLock.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runJobs runs the jobs queued by queueJobs.
+func (w *fileLogWriter) runJobs() {
+	w.jobsLock.Lock()
+	rotated, cleanup := w.pendingRotated, w.pendingCleanup
+	w.pendingRotated, w.pendingCleanup = nil, false
+	w.jobsLock.Unlock()
+
+	for _, r := range rotated {
+		if r.manifest != nil {
+			if err := w.writeChainManifest(r); err != nil {
+				fmt.Fprintf(os.Stderr, "FileLogWriter(%q): manifest: %s\n", w.Filename, err)
+			}
+		}
+		w.runRotateHooks(r.name)
+	}
+	if cleanup {
+		w.deleteOldLog()
+	}
+}
+
+// countLines counts the lines of the log file from offset to its end.
+// It returns the offset it stopped at.
+func (w *fileLogWriter) countLines(offset int64) (int, int64, error) {
 	fd, err := os.Open(w.Filename)
 	if err != nil {
-		return 0, err
+		return 0, offset, err
 	}
 	defer fd.Close()
 
+	if _, err = fd.Seek(offset, io.SeekStart); err != nil {
+		return 0, offset, err
+	}
+
 	buf := make([]byte, 32768) // 32k
 	count := 0
 	lineSep := []byte{'\n'}
@@ -305,17 +506,90 @@ func (w *fileLogWriter) lines() (int, error) {
 	for {
 		c, err := fd.Read(buf)
 		if err != nil && err != io.EOF {
-			return count, err
+			return count, offset, err
 		}
 
 		count += bytes.Count(buf[:c], lineSep)
+		offset += int64(c)
 
 		if err == io.EOF {
 			break
 		}
 	}
 
-	return count, nil
+	return count, offset, nil
+}
+
+// rotatedByOther reports whether Filename no longer refers to the opened file,
+// which happens when another process has rotated it.
+func (w *fileLogWriter) rotatedByOther() (bool, error) {
+	pathInfo, err := os.Stat(w.Filename)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fdInfo, err := w.fileWriter.Stat()
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(pathInfo, fdInfo), nil
+}
+
+// syncWithDisk refreshes the size and line counters from the file on disk so
+// that lines appended by other processes are taken into account. If another
+// process has already rotated the file it is reopened.
+func (w *fileLogWriter) syncWithDisk() error {
+	rotated, err := w.rotatedByOther()
+	if err != nil {
+		return err
+	}
+	if rotated {
+		return w.startLogger()
+	}
+	fInfo, err := w.fileWriter.Stat()
+	if err != nil {
+		return fmt.Errorf("get stat err: %s", err)
+	}
+	size := fInfo.Size()
+	if size < w.lineCountOffset {
+		// truncated behind our back
+		w.maxLinesCurLines = 0
+		w.lineCountOffset = 0
+	}
+	if w.MaxLines > 0 && size > w.lineCountOffset {
+		count, end, err := w.countLines(w.lineCountOffset)
+		if err != nil {
+			return err
+		}
+		w.maxLinesCurLines += count
+		size = end
+	}
+	w.lineCountOffset = size
+	w.maxSizeCurSize = int(size)
+	return nil
+}
+
+// rotate rotates the log file. In multiprocess mode the lock file is held
+// while rotating and needRotate is checked again once the lock is acquired,
+// as another process may have rotated the file in the meantime.
+func (w *fileLogWriter) rotate(logTime time.Time, needRotate func() bool) error {
+	if !w.MultiProcess {
+		return w.doRotate(logTime)
+	}
+	if err := lockFile(w.lockFd); err != nil {
+		return err
+	}
+	defer unlockFile(w.lockFd)
+
+	if err := w.syncWithDisk(); err != nil {
+		return err
+	}
+	if !needRotate() {
+		return nil
+	}
+	return w.doRotate(logTime)
 }
 
 // DoRotate means it needs to write logs into a new file.
@@ -325,6 +599,7 @@ func (w *fileLogWriter) doRotate(logTime time.Time) error {
 	// Find the next available number
 	num := w.MaxFilesCurFiles + 1
 	fName := ""
+	rotated := rotatedFile{}
 	format := ""
 	var openTime time.Time
 	rotatePerm, err := strconv.ParseInt(w.RotatePerm, 8, 64)
@@ -373,12 +648,16 @@ func (w *fileLogWriter) doRotate(logTime time.Time) error {
 		goto RESTART_LOGGER
 	}
 
+	rotated.name = fName
+	if w.chain != nil {
+		rotated.manifest = w.chain.endFile(fName)
+	}
 	err = os.Chmod(fName, os.FileMode(rotatePerm))
 
 RESTART_LOGGER:
 
 	startLoggerErr := w.startLogger()
-	go w.deleteOldLog()
+	w.queueJobs(rotated)
 
 	if startLoggerErr != nil {
 		return fmt.Errorf("rotate StartLogger: %s", startLoggerErr)
@@ -407,15 +686,13 @@ func (w *fileLogWriter) deleteOldLog() {
 		}
 		if w.Hourly {
 			if !info.IsDir() && info.ModTime().Add(1*time.Hour*time.Duration(w.MaxHours)).Before(time.Now()) {
-				if strings.HasPrefix(filepath.Base(path), filepath.Base(w.fileNameOnly)) &&
-					strings.HasSuffix(filepath.Base(path), w.suffix) {
+				if w.isLogFile(path) {
 					os.Remove(path)
 				}
 			}
 		} else if w.Daily {
 			if !info.IsDir() && info.ModTime().Add(24*time.Hour*time.Duration(w.MaxDays)).Before(time.Now()) {
-				if strings.HasPrefix(filepath.Base(path), filepath.Base(w.fileNameOnly)) &&
-					strings.HasSuffix(filepath.Base(path), w.suffix) {
+				if w.isLogFile(path) {
 					os.Remove(path)
 				}
 			}
@@ -424,9 +701,30 @@ func (w *fileLogWriter) deleteOldLog() {
 	})
 }
 
-// Destroy close the file description, close file writer.
+// isLogFile reports whether path is one of the log files or chain manifests of w.
+func (w *fileLogWriter) isLogFile(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, filepath.Base(w.fileNameOnly)) &&
+		(strings.HasSuffix(base, w.suffix) || strings.HasSuffix(base, w.suffix+chainManifestSuffix))
+}
+
+// writeChainManifest writes the signed manifest of a rotated file.
+func (w *fileLogWriter) writeChainManifest(r rotatedFile) error {
+	rotatePerm, err := strconv.ParseInt(w.RotatePerm, 8, 64)
+	if err != nil {
+		return err
+	}
+	return writeChainManifest(r.name, r.manifest, w.chain.key, os.FileMode(rotatePerm))
+}
+
+// Destroy stops the scheduler, close the file description, close file writer.
+// It waits for queued rotate hooks to finish.
 func (w *fileLogWriter) Destroy() {
+	w.stopScheduler()
 	w.fileWriter.Close()
+	if w.lockFd != nil {
+		w.lockFd.Close()
+	}
 }
 
 // Flush flushes file logger.
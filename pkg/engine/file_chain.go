@@ -0,0 +1,501 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// In chain mode every line written by the file adapter ends with
+//
+//	<tab>chain:<keyid>:<seq>:<mac>
+//
+// where mac is the HMAC-SHA256 of the previous mac, the sequence number and
+// the line itself. The first line of each file also carries the mac it was
+// chained to, and each rotated file gets a signed manifest next to it.
+const (
+	chainMarker         = "\tchain:"
+	chainManifestSuffix = ".manifest"
+)
+
+var chainGenesis = make([]byte, sha256.Size)
+
+// hashChain holds the running HMAC chain of a fileLogWriter.
+type hashChain struct {
+	keyID string
+	key   []byte
+	seq   uint64
+	mac   []byte
+
+	// the first entry of the current file, firstSeq is 0 for an empty file
+	firstSeq  uint64
+	firstPrev []byte
+}
+
+// ChainManifest describes a rotated file of a hash chained log.
+// It is signed with the HMAC key KeyID.
+type ChainManifest struct {
+	File      string `json:"file"`
+	KeyID     string `json:"keyid"`
+	FirstSeq  uint64 `json:"first_seq"`
+	LastSeq   uint64 `json:"last_seq"`
+	PrevMAC   string `json:"prev_mac"`
+	LastMAC   string `json:"last_mac"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+func (m *ChainManifest) sign(key []byte) string {
+	unsigned := *m
+	unsigned.Signature = ""
+	payload, _ := json.Marshal(&unsigned)
+	h := hmac.New(sha256.New, key)
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ChainError reports the first line of a hash chained log failing the verification.
+type ChainError struct {
+	File   string
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Reason)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Reason)
+}
+
+// ChainReport summarizes a verified hash chained log.
+type ChainReport struct {
+	Files    []string
+	Entries  uint64
+	FirstSeq uint64
+	LastSeq  uint64
+}
+
+type chainEntry struct {
+	keyID string
+	seq   uint64
+	mac   []byte
+	prev  []byte // only set on the first entry of a file
+}
+
+// ParseChainKeys decodes hex encoded HMAC keys by key id.
+func ParseChainKeys(keys map[string]string) (map[string][]byte, error) {
+	res := make(map[string][]byte, len(keys))
+	for id, k := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid chain key id %q", id)
+		}
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("chain key %s: %s", id, err)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("chain key %s is empty", id)
+		}
+		res[id] = key
+	}
+	return res, nil
+}
+
+// newHashChain returns a chain signing with the key keyID of keys.
+// keyID may be empty when keys holds a single key.
+func newHashChain(keys map[string]string, keyID string) (*hashChain, error) {
+	decoded, err := ParseChainKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" && len(decoded) == 1 {
+		for id := range decoded {
+			keyID = id
+		}
+	}
+	key, ok := decoded[keyID]
+	if !ok {
+		return nil, fmt.Errorf("the chain key with id: %s not found", keyID)
+	}
+	return &hashChain{keyID: keyID, key: key, mac: chainGenesis}, nil
+}
+
+func chainMAC(key, prev []byte, seq uint64, record string) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	h := hmac.New(sha256.New, key)
+	h.Write(prev)
+	h.Write(buf[:])
+	io.WriteString(h, record)
+	return h.Sum(nil)
+}
+
+// seal appends the next chain entry to msg. The chain only moves on once
+// commit is called with the returned seq and mac.
+func (c *hashChain) seal(msg string) (string, uint64, []byte) {
+	record := strings.TrimSuffix(msg, "\n")
+	seq := c.seq + 1
+	mac := chainMAC(c.key, c.mac, seq, record)
+	entry := record + chainMarker + c.keyID + ":" + strconv.FormatUint(seq, 10) + ":" + hex.EncodeToString(mac)
+	if c.firstSeq == 0 {
+		entry += ":" + hex.EncodeToString(c.mac)
+	}
+	return entry + "\n", seq, mac
+}
+
+func (c *hashChain) commit(seq uint64, mac []byte) {
+	if c.firstSeq == 0 {
+		c.firstSeq = seq
+		c.firstPrev = c.mac
+	}
+	c.seq, c.mac = seq, mac
+}
+
+// endFile returns the unsigned manifest of the file just rotated to name.
+// The following entries start a new file.
+func (c *hashChain) endFile(name string) *ChainManifest {
+	m := &ChainManifest{
+		File:     filepath.Base(name),
+		KeyID:    c.keyID,
+		FirstSeq: c.firstSeq,
+		LastSeq:  c.seq,
+		PrevMAC:  hex.EncodeToString(c.firstPrev),
+		LastMAC:  hex.EncodeToString(c.mac),
+	}
+	if c.firstSeq == 0 {
+		m.FirstSeq = c.seq + 1
+		m.PrevMAC = m.LastMAC
+	}
+	c.firstSeq, c.firstPrev = 0, nil
+	return m
+}
+
+// resume continues the chain of an existing log file. When the file holds no
+// entry yet, the chain continues from the newest manifest of its rotated files.
+func (c *hashChain) resume(w *fileLogWriter) error {
+	first, last, err := chainBounds(w.Filename)
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		c.seq, c.mac = last.seq, last.mac
+		c.firstSeq, c.firstPrev = first.seq, first.prev
+		return nil
+	}
+
+	manifests, _ := filepath.Glob(w.fileNameOnly + ".*" + w.suffix + chainManifestSuffix)
+	for _, name := range manifests {
+		m, err := readChainManifest(name)
+		if err != nil || m.LastSeq < c.seq {
+			continue
+		}
+		mac, err := hex.DecodeString(m.LastMAC)
+		if err != nil {
+			continue
+		}
+		c.seq, c.mac = m.LastSeq, mac
+	}
+	return nil
+}
+
+// writeChainManifest signs m and writes it next to the rotated file.
+func writeChainManifest(rotated string, m *ChainManifest, key []byte, perm os.FileMode) error {
+	sum, err := fileSHA256(rotated)
+	if err != nil {
+		return err
+	}
+	m.SHA256 = sum
+	m.Signature = m.sign(key)
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := rotated + chainManifestSuffix
+	if err = ioutil.WriteFile(name, body, perm); err != nil {
+		return err
+	}
+	return os.Chmod(name, perm)
+}
+
+func readChainManifest(name string) (*ChainManifest, error) {
+	body, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	m := &ChainManifest{}
+	return m, json.Unmarshal(body, m)
+}
+
+func fileSHA256(name string) (string, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parseChainEntry splits a line into the record and its chain entry.
+func parseChainEntry(line string) (string, *chainEntry, bool) {
+	pos := strings.LastIndex(line, chainMarker)
+	if pos < 0 {
+		return line, nil, false
+	}
+	parts := strings.Split(line[pos+len(chainMarker):], ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		return line, nil, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return line, nil, false
+	}
+	e := &chainEntry{keyID: parts[0], seq: seq}
+	if e.mac, err = hex.DecodeString(parts[2]); err != nil || len(e.mac) != sha256.Size {
+		return line, nil, false
+	}
+	if len(parts) == 4 {
+		if e.prev, err = hex.DecodeString(parts[3]); err != nil || len(e.prev) != sha256.Size {
+			return line, nil, false
+		}
+	}
+	return line[:pos], e, true
+}
+
+// chainBounds returns the first and the last chain entry of a log file.
+func chainBounds(name string) (first, last *chainEntry, err error) {
+	fd, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fd.Close()
+
+	r := bufio.NewReader(fd)
+	for {
+		line, err := r.ReadString('\n')
+		if _, e, ok := parseChainEntry(strings.TrimSuffix(line, "\n")); ok {
+			if first == nil {
+				first = e
+			}
+			last = e
+		}
+		if err == io.EOF {
+			return first, last, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// chainFiles returns the rotated files of filename in rotation order,
+// followed by filename itself.
+func chainFiles(filename string) ([]string, error) {
+	suffix := filepath.Ext(filename)
+	fileNameOnly := strings.TrimSuffix(filename, suffix)
+	if suffix == "" {
+		suffix = ".log"
+	}
+	rotatedName := regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(fileNameOnly)) +
+		`\.[0-9-]*\.[0-9]{3}` + regexp.QuoteMeta(suffix) + `$`)
+
+	matches, err := filepath.Glob(fileNameOnly + ".*" + suffix)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range matches {
+		if rotatedName.MatchString(filepath.Base(m)) {
+			files = append(files, m)
+		}
+	}
+	sort.Strings(files)
+	if _, err := os.Stat(filename); err == nil {
+		files = append(files, filename)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no log file found", filename)
+	}
+	return files, nil
+}
+
+// chainVerifier walks the entries of consecutive files of one chain.
+type chainVerifier struct {
+	keys    map[string][]byte
+	started bool
+	seq     uint64
+	mac     []byte
+	report  *ChainReport
+}
+
+// VerifyChain verifies the hash chain of a log file written in chain mode
+// and of all the files it was rotated to. It returns a *ChainError
+// describing the first tampered, missing or unsealed line.
+func VerifyChain(filename string, keys map[string][]byte) (*ChainReport, error) {
+	files, err := chainFiles(filename)
+	if err != nil {
+		return nil, err
+	}
+	v := &chainVerifier{keys: keys, report: &ChainReport{}}
+	for _, file := range files {
+		if err := v.verifyFile(file, file != filename); err != nil {
+			return v.report, err
+		}
+		v.report.Files = append(v.report.Files, file)
+	}
+	return v.report, nil
+}
+
+func (v *chainVerifier) verifyFile(file string, rotated bool) error {
+	var manifest *ChainManifest
+	if rotated {
+		m, err := readChainManifest(file + chainManifestSuffix)
+		if err != nil {
+			return &ChainError{File: file, Reason: fmt.Sprintf("manifest: %s", err)}
+		}
+		key, ok := v.keys[m.KeyID]
+		if !ok {
+			return &ChainError{File: file, Reason: fmt.Sprintf("manifest: unknown key id %s", m.KeyID)}
+		}
+		if !hmac.Equal([]byte(m.Signature), []byte(m.sign(key))) {
+			return &ChainError{File: file, Reason: "manifest: invalid signature"}
+		}
+		manifest = m
+	}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	r := bufio.NewReader(fd)
+	h := sha256.New()
+	var record []string
+	lineNum, recordLine, fileEntries := 0, 0, 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		h.Write([]byte(line))
+		if len(line) > 0 {
+			lineNum++
+			if len(record) == 0 {
+				recordLine = lineNum
+			}
+			text, e, ok := parseChainEntry(strings.TrimSuffix(line, "\n"))
+			record = append(record, text)
+			if ok {
+				if cerr := v.verifyEntry(e, strings.Join(record, "\n"), fileEntries == 0); cerr != nil {
+					cerr.File, cerr.Line = file, recordLine
+					return cerr
+				}
+				if fileEntries == 0 && manifest != nil && hex.EncodeToString(e.prev) != manifest.PrevMAC {
+					return &ChainError{File: file, Line: recordLine, Seq: e.seq, Reason: "chain start does not match the manifest"}
+				}
+				fileEntries++
+				record = record[:0]
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(record) > 0 {
+		return &ChainError{File: file, Line: recordLine, Seq: v.seq + 1, Reason: "line is not sealed by the chain"}
+	}
+
+	if manifest != nil {
+		switch {
+		case manifest.LastSeq != v.seq && fileEntries > 0:
+			return &ChainError{File: file, Line: lineNum, Seq: v.seq, Reason: fmt.Sprintf("file ends at seq %d, manifest expects %d", v.seq, manifest.LastSeq)}
+		case fileEntries > 0 && manifest.LastMAC != hex.EncodeToString(v.mac):
+			return &ChainError{File: file, Line: lineNum, Seq: v.seq, Reason: "last entry does not match the manifest"}
+		case manifest.SHA256 != hex.EncodeToString(h.Sum(nil)):
+			return &ChainError{File: file, Reason: "file content does not match the manifest checksum"}
+		}
+	}
+	return nil
+}
+
+func (v *chainVerifier) verifyEntry(e *chainEntry, record string, firstInFile bool) *ChainError {
+	key, ok := v.keys[e.keyID]
+	if !ok {
+		return &ChainError{Seq: e.seq, Reason: fmt.Sprintf("unknown key id %s", e.keyID)}
+	}
+
+	prev := v.mac
+	if !v.started {
+		switch {
+		case e.prev != nil:
+			prev = e.prev
+		case e.seq == 1:
+			prev = chainGenesis
+		default:
+			return &ChainError{Seq: e.seq, Reason: "cannot find the start of the chain"}
+		}
+		v.seq = e.seq - 1
+		v.report.FirstSeq = e.seq
+	} else {
+		if e.seq > v.seq+1 {
+			return &ChainError{Seq: e.seq, Reason: fmt.Sprintf("%d line(s) missing before seq %d", e.seq-v.seq-1, e.seq)}
+		}
+		if e.seq <= v.seq {
+			return &ChainError{Seq: e.seq, Reason: fmt.Sprintf("seq %d repeated or out of order, expected %d", e.seq, v.seq+1)}
+		}
+		if firstInFile && e.prev != nil && !bytes.Equal(e.prev, v.mac) {
+			return &ChainError{Seq: e.seq, Reason: "file is not chained to the previous file"}
+		}
+	}
+
+	if !hmac.Equal(e.mac, chainMAC(key, prev, e.seq, record)) {
+		return &ChainError{Seq: e.seq, Reason: "line has been tampered with"}
+	}
+	v.started = true
+	v.seq, v.mac = e.seq, e.mac
+	v.report.Entries++
+	v.report.LastSeq = e.seq
+	return nil
+}
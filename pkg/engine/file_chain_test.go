@@ -0,0 +1,116 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testChainConfig = `{"filename":"test_chain/app.log","maxlines":4,"chain":true,"chainkeys":{"k1":"6b6579"}}`
+
+var testChainKeys = map[string][]byte{"k1": []byte("key")}
+
+func writeTestChain(t *testing.T, n int) {
+	w := newFileWriter().(*fileLogWriter)
+	assert.Nil(t, w.Init(testChainConfig))
+	for i := 0; i < n; i++ {
+		lm := &LogMsg{
+			Level: LevelDebug,
+			Msg:   fmt.Sprintf("message %d", i),
+			When:  time.Now(),
+		}
+		assert.Nil(t, w.WriteMsg(lm))
+	}
+	w.Destroy()
+}
+
+func TestFileChainVerify(t *testing.T) {
+	defer os.RemoveAll("test_chain")
+	writeTestChain(t, 6)
+	// resume the chain after a restart
+	writeTestChain(t, 4)
+
+	report, err := VerifyChain("test_chain/app.log", testChainKeys)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), report.Entries)
+	assert.Equal(t, uint64(1), report.FirstSeq)
+	assert.Equal(t, uint64(10), report.LastSeq)
+	assert.Equal(t, 3, len(report.Files))
+
+	manifests, _ := filepath.Glob("test_chain/app.*.log" + chainManifestSuffix)
+	assert.Equal(t, 2, len(manifests))
+
+	_, err = VerifyChain("test_chain/app.log", map[string][]byte{"k1": []byte("other")})
+	assert.NotNil(t, err)
+}
+
+func TestFileChainTampered(t *testing.T) {
+	defer os.RemoveAll("test_chain")
+	writeTestChain(t, 10)
+
+	rotated := fmt.Sprintf("test_chain/app.%s.%03d.log", time.Now().Format("2006-01-02"), 2)
+	content, err := ioutil.ReadFile(rotated)
+	assert.Nil(t, err)
+	os.Chmod(rotated, 0660)
+	assert.Nil(t, ioutil.WriteFile(rotated, []byte(strings.Replace(string(content), "message 6", "message X", 1)), 0660))
+
+	_, err = VerifyChain("test_chain/app.log", testChainKeys)
+	if assert.IsType(t, &ChainError{}, err) {
+		cerr := err.(*ChainError)
+		assert.Equal(t, rotated, cerr.File)
+		assert.Equal(t, 3, cerr.Line)
+		assert.Equal(t, uint64(7), cerr.Seq)
+	}
+}
+
+func TestFileChainMissingLine(t *testing.T) {
+	defer os.RemoveAll("test_chain")
+	writeTestChain(t, 10)
+
+	content, err := ioutil.ReadFile("test_chain/app.log")
+	assert.Nil(t, err)
+	lines := strings.SplitAfter(string(content), "\n")
+	assert.Nil(t, ioutil.WriteFile("test_chain/app.log", []byte(lines[1]), 0660))
+
+	_, err = VerifyChain("test_chain/app.log", testChainKeys)
+	if assert.IsType(t, &ChainError{}, err) {
+		cerr := err.(*ChainError)
+		assert.Equal(t, "test_chain/app.log", cerr.File)
+		assert.Equal(t, 1, cerr.Line)
+		assert.Contains(t, cerr.Reason, "missing")
+	}
+}
+
+func TestFileChainInit(t *testing.T) {
+	w := newFileWriter().(*fileLogWriter)
+	assert.NotNil(t, w.Init(`{"filename":"test_chain/app.log","chain":true,"chainkeys":{"k1":"6b6579"},"chainkeyid":"k2"}`))
+	w = newFileWriter().(*fileLogWriter)
+	assert.NotNil(t, w.Init(`{"filename":"test_chain/app.log","chain":true,"multiprocess":true,"chainkeys":{"k1":"6b6579"}}`))
+	os.RemoveAll("test_chain")
+}
@@ -0,0 +1,81 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RotateHook is invoked by the file adapter after a log file has been
+// rotated. rotated is the path the finished file was renamed to.
+type RotateHook func(rotated string) error
+
+var rotateHookMap = make(map[string]RotateHook, 4)
+
+// RegisterRotateHook registers a rotate hook so file adapters can refer to it
+// by name from their json config. for example:
+// RegisterRotateHook("archive", func(rotated string) error { return upload(rotated) })
+// logs.SetLogger(AdapterFile, `{"filename":"logs/app.log","rotatehooks":["archive"]}`)
+func RegisterRotateHook(name string, hook RotateHook) {
+	rotateHookMap[name] = hook
+}
+
+// GetRotateHook returns the rotate hook registered with name.
+func GetRotateHook(name string) (RotateHook, bool) {
+	res, ok := rotateHookMap[name]
+	return res, ok
+}
+
+// commandRotateHook returns a RotateHook running the given command with the
+// rotated file path appended as its last argument.
+func commandRotateHook(command []string) RotateHook {
+	return func(rotated string) error {
+		args := append(append([]string{}, command[1:]...), rotated)
+		out, err := exec.Command(command[0], args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %s %s", strings.Join(command, " "), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+}
+
+// runRotateHooks runs every configured hook for rotated. It is called by the
+// scheduler of the writer, so hooks never block logging. Failures are
+// reported on stderr.
+func (w *fileLogWriter) runRotateHooks(rotated string) {
+	for _, hook := range w.rotateHooks {
+		if err := callRotateHook(hook, rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): rotate hook: %s\n", w.Filename, err)
+		}
+	}
+}
+
+func callRotateHook(hook RotateHook, rotated string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return hook(rotated)
+}
@@ -0,0 +1,89 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRotateHook(t *testing.T) {
+	rotated := make(chan string, 4)
+	RegisterRotateHook("test-hook", func(name string) error {
+		rotated <- name
+		return nil
+	})
+	RegisterRotateHook("test-hook-err", func(name string) error {
+		return errors.New("archive is down")
+	})
+
+	log := NewLogger(10000)
+	assert.Nil(t, log.SetLogger(AdapterFile, `{"filename":"test_hook.log","maxlines":4,"rotatehooks":["test-hook-err","test-hook"]}`))
+	log.Debug("debug")
+	log.Info("info")
+	log.Notice("notice")
+	log.Warning("warning")
+	log.Error("error")
+
+	rotateName := "test_hook" + fmt.Sprintf(".%s.%03d", time.Now().Format("2006-01-02"), 1) + ".log"
+	select {
+	case name := <-rotated:
+		assert.Equal(t, rotateName, name)
+	case <-time.After(5 * time.Second):
+		t.Error("rotate hook was not called")
+	}
+	log.Close()
+	os.Remove(rotateName)
+	os.Remove("test_hook.log")
+}
+
+func TestFileRotateHookNotFound(t *testing.T) {
+	fw := newFileWriter().(*fileLogWriter)
+	err := fw.Init(`{"filename":"test_hook.log","rotatehooks":["missing"]}`)
+	assert.NotNil(t, err)
+}
+
+func TestFileRotateCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("rotatecmd test relies on sh")
+	}
+	log := NewLogger(10000)
+	assert.Nil(t, log.SetLogger(AdapterFile, `{"filename":"test_cmd.log","maxlines":2,"rotatecmd":["sh","-c","cp \"$0\" \"$0.copy\""]}`))
+	log.Debug("debug")
+	log.Info("info")
+	log.Notice("notice")
+	// Destroy waits for the running hooks
+	log.Close()
+
+	rotateName := "test_cmd" + fmt.Sprintf(".%s.%03d", time.Now().Format("2006-01-02"), 1) + ".log"
+	b, err := exists(rotateName + ".copy")
+	assert.Nil(t, err)
+	assert.True(t, b)
+	os.Remove(rotateName + ".copy")
+	os.Remove(rotateName)
+	os.Remove("test_cmd.log")
+}
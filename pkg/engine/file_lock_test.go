@@ -0,0 +1,101 @@
+//go:build !windows
+// +build !windows
+
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMultiProcessRotate(t *testing.T) {
+	config := `{"filename":"test_mp.log","maxlines":10,"multiprocess":true}`
+	w1 := newFileWriter().(*fileLogWriter)
+	assert.Nil(t, w1.Init(config))
+	w2 := newFileWriter().(*fileLogWriter)
+	assert.Nil(t, w2.Init(config))
+
+	writers := []*fileLogWriter{w1, w2}
+	for i := 0; i < 25; i++ {
+		lm := &LogMsg{
+			Level: LevelDebug,
+			Msg:   fmt.Sprintf("message %d", i),
+			When:  time.Now(),
+		}
+		assert.Nil(t, writers[i%2].WriteMsg(lm))
+	}
+	w1.Destroy()
+	w2.Destroy()
+
+	date := time.Now().Format("2006-01-02")
+	files := []string{
+		fmt.Sprintf("test_mp.%s.%03d.log", date, 1),
+		fmt.Sprintf("test_mp.%s.%03d.log", date, 2),
+		"test_mp.log",
+	}
+	expected := []int{10, 10, 5}
+	for i, file := range files {
+		content, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[i], bytes.Count(content, []byte{'\n'}), file)
+		os.Remove(file)
+	}
+	os.Remove("test_mp.log.lock")
+}
+
+func TestFileMultiProcessReopen(t *testing.T) {
+	config := `{"filename":"test_mp2.log","multiprocess":true}`
+	w1 := newFileWriter().(*fileLogWriter)
+	assert.Nil(t, w1.Init(config))
+	w2 := newFileWriter().(*fileLogWriter)
+	assert.Nil(t, w2.Init(config))
+
+	lm := &LogMsg{Level: LevelDebug, Msg: "before", When: time.Now()}
+	assert.Nil(t, w2.WriteMsg(lm))
+
+	// w1 rotates the shared file, w2 must follow to the new file
+	w1.Lock()
+	assert.Nil(t, w1.rotate(time.Now(), func() bool { return true }))
+	w1.Unlock()
+
+	lm = &LogMsg{Level: LevelDebug, Msg: "after", When: time.Now()}
+	assert.Nil(t, w2.WriteMsg(lm))
+	w1.Destroy()
+	w2.Destroy()
+
+	content, err := ioutil.ReadFile("test_mp2.log")
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "after")
+	assert.NotContains(t, string(content), "before")
+
+	rotateName := fmt.Sprintf("test_mp2.%s.%03d.log", time.Now().Format("2006-01-02"), 1)
+	os.Remove(rotateName)
+	os.Remove("test_mp2.log")
+	os.Remove("test_mp2.log.lock")
+}
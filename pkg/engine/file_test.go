@@ -25,6 +25,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"testing"
 	"time"
@@ -322,9 +324,7 @@ func testFileDailyRotate(t *testing.T, fn1, fn2 string) {
 	fw.Init(fmt.Sprintf(`{"filename":"%v","maxdays":1}`, fn1))
 	fw.dailyOpenTime = time.Now().Add(-24 * time.Hour)
 	fw.dailyOpenDate = fw.dailyOpenTime.Day()
-	today, _ := time.ParseInLocation("2006-01-02", time.Now().Format("2006-01-02"), fw.dailyOpenTime.Location())
-	today = today.Add(-1 * time.Second)
-	fw.dailyRotate(today)
+	fw.scheduledRotate()
 	for _, file := range []string{fn1, fn2} {
 		_, err := os.Stat(file)
 		if err != nil {
@@ -356,9 +356,7 @@ func testFileHourlyRotate(t *testing.T, fn1, fn2 string) {
 	fw.Init(fmt.Sprintf(`{"filename":"%v","maxhours":1}`, fn1))
 	fw.hourlyOpenTime = time.Now().Add(-1 * time.Hour)
 	fw.hourlyOpenDate = fw.hourlyOpenTime.Hour()
-	hour, _ := time.ParseInLocation("2006010215", time.Now().Format("2006010215"), fw.hourlyOpenTime.Location())
-	hour = hour.Add(-1 * time.Second)
-	fw.hourlyRotate(hour)
+	fw.scheduledRotate()
 	for _, file := range []string{fn1, fn2} {
 		_, err := os.Stat(file)
 		if err != nil {
@@ -375,6 +373,43 @@ func testFileHourlyRotate(t *testing.T, fn1, fn2 string) {
 	}
 	fw.Destroy()
 }
+func TestFileNextRotateTime(t *testing.T) {
+	now := time.Date(2020, 12, 31, 23, 12, 37, 9, time.UTC)
+	fw := &fileLogWriter{Daily: true}
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 100, time.UTC), fw.nextRotateTime(now))
+	fw.Hourly = true
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 100, time.UTC), fw.nextRotateTime(now))
+	now = time.Date(2020, 12, 31, 8, 59, 59, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 12, 31, 9, 0, 0, 100, time.UTC), fw.nextRotateTime(now))
+}
+
+func TestFileNoGoroutineLeak(t *testing.T) {
+	RegisterRotateHook("test-leak", func(string) error { return nil })
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		log := NewLogger(10000)
+		assert.Nil(t, log.SetLogger(AdapterFile, `{"filename":"test_leak.log","maxlines":2,"rotatehooks":["test-leak"]}`))
+		assert.Nil(t, log.SetLogger(AdapterMultiFile, `{"filename":"test_leak_multi.log","hourly":true,"separate":["error"]}`))
+		log.Debug("debug")
+		log.Info("info")
+		log.Error("error")
+		assert.Nil(t, log.DelLogger(AdapterFile))
+		assert.Nil(t, log.DelLogger(AdapterMultiFile))
+	}
+
+	after := runtime.NumGoroutine()
+	for i := 0; i < 50 && after > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	assert.LessOrEqual(t, after, before)
+
+	files, _ := filepath.Glob("test_leak*.log")
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
 func exists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -72,7 +72,7 @@ func (p *PatternLogFormatter) ToString(lm *LogMsg) string {
 	s := []rune(p.Pattern)
 	m := map[rune]string{
 		'w': lm.When.Format(p.getWhenFormatter()),
-		'm': lm.Msg,
+		'm': lm.Message(),
 		'n': strconv.Itoa(lm.LineNumber),
 		'l': strconv.Itoa(lm.Level),
 		't': levelPrefix[lm.Level-1],
@@ -99,3 +99,16 @@ func TestPatternLogFormatter(t *testing.T) {
 		t.Errorf("want %s, got %s", want, got)
 	}
 }
+
+func TestPatternLogFormatterArgs(t *testing.T) {
+	tes := &PatternLogFormatter{Pattern: "%m"}
+	lm := &LogMsg{
+		Msg:   "order %d failed",
+		Args:  []interface{}{7},
+		Level: LevelError,
+		When:  time.Now(),
+	}
+	if got := tes.ToString(lm); got != "order 7 failed" {
+		t.Errorf("want %q, got %q", "order 7 failed", got)
+	}
+}
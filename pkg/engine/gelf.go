@@ -0,0 +1,323 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// GELF compression of udp messages.
+const (
+	GELFGzip = "gzip"
+	GELFZlib = "zlib"
+	GELFNone = "none"
+)
+
+const (
+	gelfChunkHeader  = 12 // magic bytes, message id, sequence number and count
+	gelfMaxChunks    = 128
+	defaultGELFChunk = 1420
+)
+
+// gelfWriter implements LoggerInterface.
+// It sends GELF 1.1 messages to Graylog over udp, tcp or tls.
+type gelfWriter struct {
+	lock      sync.Mutex
+	conn      net.Conn
+	formatter LogFormatter
+
+	Formatter   string                 `json:"formatter"`
+	Net         string                 `json:"net"`
+	Addr        string                 `json:"addr"`
+	Level       int                    `json:"level"`
+	Host        string                 `json:"host"`
+	Compression string                 `json:"compression"`
+	ChunkSize   int                    `json:"chunkSize"`
+	Fields      map[string]interface{} `json:"fields"`
+	TLSOptions
+}
+
+// NewGELF creates a GELF writer returning as LoggerInterface.
+func NewGELF() Logger {
+	g := &gelfWriter{
+		Net:         "udp",
+		Level:       LevelDebug,
+		Compression: GELFGzip,
+		ChunkSize:   defaultGELFChunk,
+	}
+	g.formatter = g
+	return g
+}
+
+// Init initializes the GELF writer with json config.
+// udp messages are compressed with gzip (default), zlib or none and are
+// chunked above chunkSize bytes. tcp and tls send uncompressed messages
+// terminated by a NUL byte. fields are added to every message:
+//
+//	{
+//	"net":"udp",
+//	"addr":"graylog.bhojpur.net:12201",
+//	"compression":"gzip",
+//	"chunkSize":1420,
+//	"host":"web-1",
+//	"fields":{"environment":"production"},
+//	"level":6
+//	}
+func (g *gelfWriter) Init(config string) error {
+	if err := json.Unmarshal([]byte(config), g); err != nil {
+		return err
+	}
+	if len(g.Formatter) > 0 {
+		fmtr, ok := GetFormatter(g.Formatter)
+		if !ok {
+			return errors.New(fmt.Sprintf("the formatter with name: %s not found", g.Formatter))
+		}
+		g.formatter = fmtr
+	}
+	switch g.Net {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tls":
+	default:
+		return errors.New(fmt.Sprintf("unknown gelf network: %s", g.Net))
+	}
+	switch g.Compression {
+	case GELFGzip, GELFZlib, GELFNone:
+	default:
+		return errors.New(fmt.Sprintf("unknown gelf compression: %s", g.Compression))
+	}
+	if g.ChunkSize <= gelfChunkHeader {
+		return errors.New(fmt.Sprintf("gelf chunkSize must be more than %d", gelfChunkHeader))
+	}
+	if g.Host == "" {
+		g.Host, _ = os.Hostname()
+	}
+	return nil
+}
+
+func (g *gelfWriter) SetFormatter(f LogFormatter) {
+	g.formatter = f
+}
+
+// Format returns the message text. Level, prefix and caller are sent as
+// separate GELF fields.
+func (g *gelfWriter) Format(lm *LogMsg) string {
+	return lm.Message()
+}
+
+// WriteMsg sends one GELF message.
+// The connection is set up again once when writing fails. A udp message
+// too large for gelfMaxChunks chunks is dropped with an error.
+func (g *gelfWriter) WriteMsg(lm *LogMsg) error {
+	if lm.Level > g.Level {
+		return nil
+	}
+	payload, err := g.payload(lm)
+	if err != nil {
+		return err
+	}
+	if g.datagram() && g.chunks(len(payload)) > gelfMaxChunks {
+		return errors.New(fmt.Sprintf("gelf message of %d bytes needs more than %d chunks, dropped", len(payload), gelfMaxChunks))
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for i := 0; i < 2; i++ {
+		if g.conn == nil {
+			if err = g.connect(); err != nil {
+				continue
+			}
+		}
+		if err = g.send(payload); err == nil {
+			return nil
+		}
+		g.conn.Close()
+		g.conn = nil
+	}
+	return err
+}
+
+func (g *gelfWriter) connect() error {
+	var conn net.Conn
+	var err error
+	if g.Net == "tls" {
+		cfg, cfgErr := g.ClientConfig(g.Addr)
+		if cfgErr != nil {
+			return cfgErr
+		}
+		conn, err = tls.Dial("tcp", g.Addr, cfg)
+	} else {
+		conn, err = net.Dial(g.Net, g.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	g.conn = conn
+	return nil
+}
+
+func (g *gelfWriter) datagram() bool {
+	return strings.HasPrefix(g.Net, "udp")
+}
+
+// payload returns the encoded message as it goes on the wire, before
+// chunking.
+func (g *gelfWriter) payload(lm *LogMsg) ([]byte, error) {
+	data, err := json.Marshal(g.message(lm))
+	if err != nil {
+		return nil, err
+	}
+	if !g.datagram() {
+		return append(data, 0), nil
+	}
+
+	var b bytes.Buffer
+	switch g.Compression {
+	case GELFGzip:
+		w := gzip.NewWriter(&b)
+		w.Write(data)
+		err = w.Close()
+	case GELFZlib:
+		w := zlib.NewWriter(&b)
+		w.Write(data)
+		err = w.Close()
+	default:
+		return data, nil
+	}
+	return b.Bytes(), err
+}
+
+// message maps lm to the GELF 1.1 fields.
+func (g *gelfWriter) message(lm *LogMsg) map[string]interface{} {
+	text := g.formatter.Format(lm)
+	m := map[string]interface{}{
+		"version":       "1.1",
+		"host":          g.Host,
+		"short_message": text,
+		"timestamp":     float64(lm.When.UnixNano()/1e6) / 1e3,
+		"level":         lm.Level,
+	}
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		m["short_message"] = text[:i]
+		m["full_message"] = text
+	}
+	for k, v := range g.Fields {
+		if name := gelfFieldName(k); name != "" {
+			m[name] = v
+		}
+	}
+	for k, v := range lm.Fields {
+		if name := gelfFieldName(k); name != "" {
+			m[name] = v
+		}
+	}
+	if lm.FilePath != "" {
+		m["_file"] = lm.FilePath
+		m["_line"] = lm.LineNumber
+	}
+	if lm.Prefix != "" {
+		m["_prefix"] = lm.Prefix
+	}
+	return m
+}
+
+// gelfFieldName returns name as an additional field name: an underscore
+// followed by word characters, dots and dashes. _id is reserved.
+func gelfFieldName(name string) string {
+	b := []byte(strings.TrimPrefix(name, "_"))
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			b[i] = '_'
+		}
+	}
+	name = "_" + string(b)
+	if name == "_" || name == "_id" {
+		return ""
+	}
+	return name
+}
+
+// chunks returns the number of udp chunks of a payload of n bytes.
+func (g *gelfWriter) chunks(n int) int {
+	if n <= g.ChunkSize {
+		return 1
+	}
+	size := g.ChunkSize - gelfChunkHeader
+	return (n + size - 1) / size
+}
+
+func (g *gelfWriter) send(payload []byte) error {
+	if !g.datagram() || len(payload) <= g.ChunkSize {
+		_, err := g.conn.Write(payload)
+		return err
+	}
+
+	size := g.ChunkSize - gelfChunkHeader
+	count := g.chunks(len(payload))
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, g.ChunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk = append(chunk[:0], 0x1e, 0x0f)
+		chunk = append(chunk, id[:]...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*size:end]...)
+		if _, err := g.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush implementing method. empty.
+func (g *gelfWriter) Flush() {
+
+}
+
+// Destroy closes the connection to Graylog.
+func (g *gelfWriter) Destroy() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+func init() {
+	Register(AdapterGELF, NewGELF)
+}
@@ -0,0 +1,189 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readGELFDatagrams reads one message from pc, reassembling chunks.
+func readGELFDatagrams(t *testing.T, pc net.PacketConn) []byte {
+	buf := make([]byte, 65536)
+	var chunks [][]byte
+	for {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		data := append([]byte(nil), buf[:n]...)
+		if data[0] != 0x1e || data[1] != 0x0f {
+			return data
+		}
+		if chunks == nil {
+			chunks = make([][]byte, data[11])
+		}
+		chunks[data[10]] = data[12:]
+		done := true
+		for _, c := range chunks {
+			done = done && c != nil
+		}
+		if done {
+			return bytes.Join(chunks, nil)
+		}
+	}
+}
+
+func decodeGELF(t *testing.T, data []byte) map[string]interface{} {
+	var r io.Reader = bytes.NewReader(data)
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(r)
+		assert.Nil(t, err)
+		r = zr
+	case data[0] == 0x78:
+		zr, err := zlib.NewReader(r)
+		assert.Nil(t, err)
+		r = zr
+	}
+	raw, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	m := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(raw, &m))
+	return m
+}
+
+func TestGELFUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	g := NewGELF()
+	assert.Nil(t, g.Init(fmt.Sprintf(`{"addr":"%s","host":"web-1","fields":{"env":"test"},"level":6}`, pc.LocalAddr())))
+	defer g.Destroy()
+	assert.Nil(t, g.WriteMsg(&LogMsg{Level: LevelDebug, Msg: "filtered", When: time.Now()}))
+	assert.Nil(t, g.WriteMsg(&LogMsg{
+		Level:      LevelError,
+		Msg:        "order %d failed\nstack",
+		Args:       []interface{}{7},
+		When:       time.Unix(1600546357, 123e6),
+		FilePath:   "/src/main.go",
+		LineNumber: 13,
+		Prefix:     "api",
+		Fields:     map[string]interface{}{"user id": "bob", "id": 1},
+	}))
+
+	assert.Equal(t, map[string]interface{}{
+		"version":       "1.1",
+		"host":          "web-1",
+		"short_message": "order 7 failed",
+		"full_message":  "order 7 failed\nstack",
+		"timestamp":     1600546357.123,
+		"level":         float64(LevelError),
+		"_file":         "/src/main.go",
+		"_line":         float64(13),
+		"_prefix":       "api",
+		"_env":          "test",
+		"_user_id":      "bob",
+	}, decodeGELF(t, readGELFDatagrams(t, pc)))
+}
+
+func TestGELFChunked(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	g := NewGELF()
+	assert.Nil(t, g.Init(fmt.Sprintf(`{"addr":"%s","compression":"zlib","chunkSize":100}`, pc.LocalAddr())))
+	defer g.Destroy()
+	// random-looking text does not compress into one chunk
+	var msg strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&msg, "%x", i*7919)
+	}
+	assert.Nil(t, g.WriteMsg(&LogMsg{Level: LevelInfo, Msg: msg.String(), When: time.Now()}))
+	assert.Equal(t, msg.String(), decodeGELF(t, readGELFDatagrams(t, pc))["short_message"])
+
+	assert.NotNil(t, NewGELF().Init(`{"chunkSize":12}`))
+	assert.NotNil(t, NewGELF().Init(`{"compression":"lz4"}`))
+}
+
+func TestGELFOversize(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	g := NewGELF().(*gelfWriter)
+	assert.Nil(t, g.Init(fmt.Sprintf(`{"addr":"%s","compression":"none","chunkSize":20}`, pc.LocalAddr())))
+	defer g.Destroy()
+	assert.Nil(t, g.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "first", When: time.Now()}))
+	assert.Equal(t, "first", decodeGELF(t, readGELFDatagrams(t, pc))["short_message"])
+	conn := g.conn
+
+	// a message past 128 chunks is dropped, keeping the connection
+	assert.NotNil(t, g.WriteMsg(&LogMsg{Level: LevelInfo, Msg: strings.Repeat("x", 2000), When: time.Now()}))
+	assert.Equal(t, conn, g.conn)
+	assert.Nil(t, g.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "next", When: time.Now()}))
+	assert.Equal(t, "next", decodeGELF(t, readGELFDatagrams(t, pc))["short_message"])
+}
+
+func TestGELFTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	msgs := make(chan []byte, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := r.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			msgs <- msg[:len(msg)-1]
+		}
+	}()
+
+	g := NewGELF()
+	assert.Nil(t, g.Init(fmt.Sprintf(`{"net":"tcp","addr":"%s"}`, ln.Addr())))
+	defer g.Destroy()
+	assert.Nil(t, g.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "first", When: time.Now()}))
+	assert.Nil(t, g.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "second", When: time.Now()}))
+	assert.Equal(t, "first", decodeGELF(t, <-msgs)["short_message"])
+	assert.Equal(t, "second", decodeGELF(t, <-msgs)["short_message"])
+}
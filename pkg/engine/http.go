@@ -0,0 +1,325 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPOptions holds the settings shared by the adapters posting
+// messages to HTTP endpoints. timeout is in milliseconds.
+type HTTPOptions struct {
+	Headers map[string]string `json:"headers"`
+	Gzip    bool              `json:"gzip"`
+	Timeout int               `json:"timeout"`
+	TLSOptions
+	BatchOptions
+}
+
+func defaultHTTPOptions() HTTPOptions {
+	return HTTPOptions{
+		Timeout:      10000,
+		BatchOptions: defaultBatchOptions(),
+	}
+}
+
+// httpSender posts batches of messages, encoded by encode, to one url.
+// done, when set, gets the response body of every batch posted, and
+// its error has the batch retried or given up like a failed post.
+type httpSender struct {
+	method      string
+	url         string
+	contentType string
+	opts        HTTPOptions
+	encode      func(entries []BatchEntry) ([]byte, error)
+	done        func(entries []BatchEntry, res []byte) error
+	client      *http.Client
+	batcher     *Batcher
+}
+
+func newHTTPSender(name, method, url, contentType string, opts HTTPOptions,
+	encode func([]BatchEntry) ([]byte, error), done func([]BatchEntry, []byte) error) (*httpSender, error) {
+	if url == "" {
+		return nil, errors.New(fmt.Sprintf("%s: url is required", name))
+	}
+	client, err := newHTTPClient(&opts.TLSOptions, url, time.Duration(opts.Timeout)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	s := &httpSender{
+		method:      method,
+		url:         url,
+		contentType: contentType,
+		opts:        opts,
+		encode:      encode,
+		done:        done,
+		client:      client,
+	}
+	s.batcher = NewBatcher(name, opts.BatchOptions, s.send)
+	return s, nil
+}
+
+func (s *httpSender) add(lm *LogMsg, text string) {
+	s.batcher.Add(lm, text)
+}
+
+func (s *httpSender) send(entries []BatchEntry) error {
+	body, err := s.encode(entries)
+	if err != nil {
+		return err
+	}
+	res, err := s.post(s.url, body)
+	if err != nil || s.done == nil {
+		return err
+	}
+	return s.done(entries, res)
+}
+
+// post sends body to url with the headers of the sender, compressed when
+// gzip is on, and returns the response body of a 2xx status.
+func (s *httpSender) post(url string, body []byte) ([]byte, error) {
+	if s.opts.Gzip {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		w.Write(body)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		body = b.Bytes()
+	}
+	req, err := http.NewRequest(s.method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	if s.opts.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range s.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return ioutil.ReadAll(resp.Body)
+	}
+	return nil, checkHTTPResponse(resp)
+}
+
+// Flush blocks until the queued messages are posted.
+func (s *httpSender) Flush() {
+	s.batcher.Flush()
+}
+
+func (s *httpSender) close() {
+	s.batcher.Close()
+}
+
+// joinedText returns the formatted messages of a batch, one per line.
+func joinedText(entries []BatchEntry) string {
+	texts := make([]string, len(entries))
+	for i := range entries {
+		texts[i] = entries[i].Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// WebhookMessage is a message as seen by the body template of the http
+// adapter.
+type WebhookMessage struct {
+	Level     int                    `json:"level"`
+	LevelName string                 `json:"levelName"`
+	Text      string                 `json:"text"` // formatted by the adapter formatter
+	Msg       string                 `json:"msg"`
+	When      time.Time              `json:"when"`
+	File      string                 `json:"file,omitempty"`
+	Line      int                    `json:"line,omitempty"`
+	Prefix    string                 `json:"prefix,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// WebhookBatch is the data of the body template of the http adapter.
+type WebhookBatch struct {
+	Messages []WebhookMessage
+	Text     string // the Text of all messages, one per line
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"query": url.QueryEscape,
+}
+
+func newWebhookBatch(entries []BatchEntry) *WebhookBatch {
+	batch := &WebhookBatch{
+		Messages: make([]WebhookMessage, len(entries)),
+		Text:     joinedText(entries),
+	}
+	for i := range entries {
+		lm := &entries[i].Msg
+		m := WebhookMessage{
+			Level:  lm.Level,
+			Text:   entries[i].Text,
+			Msg:    lm.Message(),
+			When:   lm.When,
+			File:   lm.FilePath,
+			Line:   lm.LineNumber,
+			Prefix: lm.Prefix,
+			Fields: lm.Fields,
+		}
+		if lm.Level >= 0 && lm.Level <= LevelDebug {
+			m.LevelName = levelNames[lm.Level]
+		}
+		batch.Messages[i] = m
+	}
+	return batch
+}
+
+// httpWriter implements LoggerInterface.
+// It posts batches of messages to any HTTP endpoint, with the body
+// rendered from a template.
+type httpWriter struct {
+	formatter LogFormatter
+	sender    *httpSender
+	body      *template.Template
+
+	Formatter   string `json:"formatter"`
+	Level       int    `json:"level"`
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	ContentType string `json:"contentType"`
+	Body        string `json:"body"`
+	HTTPOptions
+}
+
+// NewHTTP creates an http writer returning as LoggerInterface.
+func NewHTTP() Logger {
+	w := &httpWriter{
+		Level:       LevelDebug,
+		Method:      http.MethodPost,
+		ContentType: "application/json",
+		Body:        "{{json .Messages}}",
+		HTTPOptions: defaultHTTPOptions(),
+	}
+	w.formatter = w
+	return w
+}
+
+// Init initializes the http writer with json config.
+// body is a text/template executed with a WebhookBatch; the json and
+// query functions encode values. A batch is posted once it holds
+// batchSize messages or batchBytes bytes of text, or after batchWait.
+// 429 and 5xx responses are retried, after Retry-After when given.
+// Durations are in milliseconds:
+//
+//	{
+//	"url":"https://hooks.bhojpur.net/logs",
+//	"method":"POST",
+//	"headers":{"Authorization":"Bearer secret"},
+//	"contentType":"application/json",
+//	"body":"{\"alerts\":{{json .Messages}}}",
+//	"batchSize":50,
+//	"batchWait":2000,
+//	"gzip":true,
+//	"timeout":5000,
+//	"retries":5,
+//	"level":4
+//	}
+func (w *httpWriter) Init(config string) error {
+	if err := json.Unmarshal([]byte(config), w); err != nil {
+		return err
+	}
+	if len(w.Formatter) > 0 {
+		fmtr, ok := GetFormatter(w.Formatter)
+		if !ok {
+			return errors.New(fmt.Sprintf("the formatter with name: %s not found", w.Formatter))
+		}
+		w.formatter = fmtr
+	}
+	body, err := template.New("body").Funcs(webhookFuncs).Parse(w.Body)
+	if err != nil {
+		return err
+	}
+	w.body = body
+	if w.sender != nil {
+		w.sender.close()
+	}
+	w.sender, err = newHTTPSender("httpWriter", w.Method, w.URL, w.ContentType, w.HTTPOptions, w.encode, nil)
+	return err
+}
+
+func (w *httpWriter) encode(entries []BatchEntry) ([]byte, error) {
+	var b bytes.Buffer
+	err := w.body.Execute(&b, newWebhookBatch(entries))
+	return b.Bytes(), err
+}
+
+func (w *httpWriter) SetFormatter(f LogFormatter) {
+	w.formatter = f
+}
+
+func (w *httpWriter) Format(lm *LogMsg) string {
+	return lm.OldStyleFormat()
+}
+
+// WriteMsg queues the message for the next post.
+func (w *httpWriter) WriteMsg(lm *LogMsg) error {
+	if lm.Level > w.Level {
+		return nil
+	}
+	w.sender.add(lm, w.formatter.Format(lm))
+	return nil
+}
+
+// Flush blocks until the queued messages are posted.
+func (w *httpWriter) Flush() {
+	if w.sender != nil {
+		w.sender.Flush()
+	}
+}
+
+// Destroy posts the queued messages and stops the writer.
+func (w *httpWriter) Destroy() {
+	if w.sender != nil {
+		w.sender.close()
+		w.sender = nil
+	}
+}
+
+func init() {
+	Register(AdapterHTTP, NewHTTP)
+}
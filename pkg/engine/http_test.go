@@ -0,0 +1,176 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookRecorder records the bodies posted to it, answering the first
+// fail requests with 429.
+type webhookRecorder struct {
+	lock     sync.Mutex
+	fail     int
+	attempts []time.Time
+	bodies   []string
+	headers  []http.Header
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.attempts = append(rec.attempts, time.Now())
+	if len(rec.attempts) <= rec.fail {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		body, _ = gzip.NewReader(r.Body)
+	}
+	b, _ := ioutil.ReadAll(body)
+	rec.bodies = append(rec.bodies, string(b))
+	rec.headers = append(rec.headers, r.Header)
+}
+
+func TestHTTPTemplate(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	w := NewHTTP()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"url":"%s","method":"PUT","headers":{"X-Token":"secret"},"gzip":true,"batchSize":2,"batchWait":60000,
+		"body":"{\"count\":{{len .Messages}},\"alerts\":[{{range $i, $m := .Messages}}{{if $i}},{{end}}{{json $m.LevelName}}{{end}}],\"text\":{{json .Text}}}"}`, srv.URL)))
+	when := time.Date(2020, 9, 19, 20, 12, 37, 0, time.UTC)
+	w.WriteMsg(&LogMsg{Level: LevelError, Msg: "first", When: when})
+	w.WriteMsg(&LogMsg{Level: LevelWarning, Msg: "second", When: when, Prefix: "api"})
+	w.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "third", When: when})
+	w.Destroy()
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	assert.Equal(t, []string{
+		`{"count":2,"alerts":["error","warning"],"text":"[E]  first\n[W] api second"}`,
+		`{"count":1,"alerts":["info"],"text":"[I]  third"}`,
+	}, rec.bodies)
+	assert.Equal(t, "secret", rec.headers[0].Get("X-Token"))
+	assert.Equal(t, "application/json", rec.headers[0].Get("Content-Type"))
+}
+
+func TestHTTPDefaultBody(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	w := NewHTTP()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"url":"%s"}`, srv.URL)))
+	w.WriteMsg(&LogMsg{Level: LevelError, Msg: "order %d failed", Args: []interface{}{7}, When: time.Now(), Fields: map[string]interface{}{"user": "bob"}})
+	w.Flush()
+
+	rec.lock.Lock()
+	var msgs []WebhookMessage
+	assert.Nil(t, json.Unmarshal([]byte(rec.bodies[0]), &msgs))
+	rec.lock.Unlock()
+	assert.Equal(t, "order 7 failed", msgs[0].Msg)
+	assert.Equal(t, "error", msgs[0].LevelName)
+	assert.Equal(t, map[string]interface{}{"user": "bob"}, msgs[0].Fields)
+	w.Destroy()
+
+	assert.NotNil(t, NewHTTP().Init(`{"url":"http://localhost","body":"{{"}`))
+	assert.NotNil(t, NewHTTP().Init(`{}`))
+}
+
+func TestHTTPBatchWaitDefault(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	w := NewHTTP()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"url":"%s","batchWait":0}`, srv.URL)))
+	defer w.Destroy()
+	assert.Equal(t, defaultBatchOptions().BatchWait, w.(*httpWriter).sender.batcher.opts.BatchWait)
+	w.WriteMsg(&LogMsg{Level: LevelError, Msg: "waited", When: time.Now()})
+	w.Flush()
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	assert.Equal(t, 1, len(rec.bodies))
+}
+
+func TestHTTPRetryAfter(t *testing.T) {
+	rec := &webhookRecorder{fail: 1}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	w := NewHTTP()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"url":"%s","retryMin":1,"retryMax":1}`, srv.URL)))
+	defer w.Destroy()
+	w.WriteMsg(&LogMsg{Level: LevelError, Msg: "limited", When: time.Now()})
+	w.Flush()
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	assert.Equal(t, 2, len(rec.attempts))
+	assert.True(t, rec.attempts[1].Sub(rec.attempts[0]) >= time.Second)
+	assert.Equal(t, 1, len(rec.bodies))
+}
+
+func TestWebhookPresets(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	when := time.Date(2020, 9, 19, 20, 12, 37, 0, time.UTC)
+	slack := newSLACKWriter()
+	assert.Nil(t, slack.Init(fmt.Sprintf(`{"webhookurl":"%s"}`, srv.URL)))
+	slack.WriteMsg(&LogMsg{Level: LevelError, Msg: "to slack", When: when})
+	slack.Destroy()
+
+	rc := newRCWriter()
+	assert.Nil(t, rc.Init(fmt.Sprintf(`{"webhookurl":"%s","authorname":"bot","title":"alerts"}`, srv.URL)))
+	rc.WriteMsg(&LogMsg{Level: LevelError, Msg: "to rc", When: when})
+	rc.Destroy()
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	var payload map[string]string
+	assert.Nil(t, json.Unmarshal([]byte(rec.bodies[0]), &payload))
+	assert.True(t, strings.HasSuffix(payload["text"], " [E]  to slack"), payload["text"])
+	assert.Equal(t, "application/x-www-form-urlencoded", rec.headers[1].Get("Content-Type"))
+	form, err := url.ParseQuery(rec.bodies[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "bot", form.Get("authorName"))
+	assert.Equal(t, "alerts", form.Get("title"))
+	assert.True(t, strings.HasSuffix(form.Get("text"), " [E]  to rc"), form.Get("text"))
+}
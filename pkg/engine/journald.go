@@ -0,0 +1,258 @@
+//go:build linux
+// +build linux
+
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// journaldWriter implements LoggerInterface.
+// It writes entries to the systemd journal with the native protocol.
+type journaldWriter struct {
+	lock      sync.Mutex
+	conn      *net.UnixConn
+	formatter LogFormatter
+
+	Formatter  string            `json:"formatter"`
+	Level      int               `json:"level"`
+	Addr       string            `json:"addr"`
+	Identifier string            `json:"identifier"`
+	Fields     map[string]string `json:"fields"`
+}
+
+// NewJournald creates a journald writer returning as LoggerInterface.
+func NewJournald() Logger {
+	j := &journaldWriter{
+		Level: LevelDebug,
+		Addr:  journalSocket,
+	}
+	j.formatter = j
+	return j
+}
+
+// Init initializes the journald writer with json config.
+// fields are added to every entry, next to the structured fields of the
+// message. Names are upper-cased as the journal requires:
+//
+//	{
+//	"identifier":"shop",
+//	"fields":{"environment":"production"},
+//	"level":6
+//	}
+func (j *journaldWriter) Init(config string) error {
+	if err := json.Unmarshal([]byte(config), j); err != nil {
+		return err
+	}
+	if len(j.Formatter) > 0 {
+		fmtr, ok := GetFormatter(j.Formatter)
+		if !ok {
+			return errors.New(fmt.Sprintf("the formatter with name: %s not found", j.Formatter))
+		}
+		j.formatter = fmtr
+	}
+	if j.Identifier == "" {
+		j.Identifier = filepath.Base(os.Args[0])
+	}
+	return nil
+}
+
+func (j *journaldWriter) SetFormatter(f LogFormatter) {
+	j.formatter = f
+}
+
+// Format returns the MESSAGE field. Priority and caller have own fields.
+func (j *journaldWriter) Format(lm *LogMsg) string {
+	if lm.Prefix == "" {
+		return lm.Message()
+	}
+	return lm.Prefix + " " + lm.Message()
+}
+
+// WriteMsg sends one journal entry.
+func (j *journaldWriter) WriteMsg(lm *LogMsg) error {
+	if lm.Level > j.Level {
+		return nil
+	}
+	entry := j.entry(lm)
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var err error
+	for i := 0; i < 2; i++ {
+		if j.conn == nil {
+			if err = j.connect(); err != nil {
+				continue
+			}
+		}
+		if err = j.send(entry); err == nil {
+			return nil
+		}
+		j.conn.Close()
+		j.conn = nil
+	}
+	return err
+}
+
+func (j *journaldWriter) connect() error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: j.Addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	j.conn = conn
+	return nil
+}
+
+// send writes entry as a datagram. Entries too large for a datagram are
+// written to a sealed memfd whose descriptor is passed instead.
+func (j *journaldWriter) send(entry []byte) error {
+	_, err := j.conn.Write(entry)
+	if err == nil || !(errors.Is(err, unix.EMSGSIZE) || errors.Is(err, unix.ENOBUFS)) {
+		return err
+	}
+
+	fd, err := unix.MemfdCreate("journal-entry", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return err
+	}
+	file := os.NewFile(uintptr(fd), "journal-entry")
+	defer file.Close()
+	if _, err = file.Write(entry); err != nil {
+		return err
+	}
+	if _, err = unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL); err != nil {
+		return err
+	}
+	// net refuses WriteMsgUnix on a connected datagram socket
+	rc, err := j.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	werr := rc.Write(func(s uintptr) bool {
+		err = unix.Sendmsg(int(s), nil, unix.UnixRights(fd), nil, 0)
+		return err != unix.EAGAIN
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
+
+// entry serializes lm in the native journal protocol.
+func (j *journaldWriter) entry(lm *LogMsg) []byte {
+	var b bytes.Buffer
+	appendJournalField(&b, "MESSAGE", j.formatter.Format(lm))
+	appendJournalField(&b, "PRIORITY", strconv.Itoa(lm.Level))
+	appendJournalField(&b, "SYSLOG_IDENTIFIER", j.Identifier)
+	if lm.FilePath != "" {
+		appendJournalField(&b, "CODE_FILE", lm.FilePath)
+		appendJournalField(&b, "CODE_LINE", strconv.Itoa(lm.LineNumber))
+	}
+
+	fields := make(map[string]string, len(j.Fields)+len(lm.Fields))
+	for k, v := range j.Fields {
+		fields[k] = v
+	}
+	for k, v := range lm.Fields {
+		fields[k] = fmt.Sprint(v)
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if name := journalFieldName(k); name != "" {
+			appendJournalField(&b, name, fields[k])
+		}
+	}
+	return b.Bytes()
+}
+
+// appendJournalField writes NAME=value, or the binary-safe form with
+// a little endian length when value spans lines.
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if strings.IndexByte(value, '\n') < 0 {
+		b.WriteByte('=')
+		b.WriteString(value)
+	} else {
+		b.WriteByte('\n')
+		var size [8]byte
+		binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+		b.Write(size[:])
+		b.WriteString(value)
+	}
+	b.WriteByte('\n')
+}
+
+// journalFieldName returns name as a journal field name: upper case
+// letters, digits and underscores, not starting with an underscore or a
+// digit, which are reserved or invalid.
+func journalFieldName(name string) string {
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	name = strings.TrimLeft(string(b), "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// Flush implementing method. empty.
+func (j *journaldWriter) Flush() {
+
+}
+
+// Destroy closes the journal socket.
+func (j *journaldWriter) Destroy() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.conn != nil {
+		j.conn.Close()
+		j.conn = nil
+	}
+}
+
+func init() {
+	Register(AdapterJournald, NewJournald)
+}
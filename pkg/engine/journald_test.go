@@ -0,0 +1,135 @@
+//go:build linux
+// +build linux
+
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// parseJournalEntry decodes an entry of the native journal protocol.
+func parseJournalEntry(t *testing.T, entry []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(entry) > 0 {
+		i := strings.IndexAny(string(entry), "=\n")
+		if !assert.True(t, i > 0) {
+			return fields
+		}
+		name := string(entry[:i])
+		if entry[i] == '=' {
+			end := i + 1 + strings.IndexByte(string(entry[i+1:]), '\n')
+			fields[name] = string(entry[i+1 : end])
+			entry = entry[end+1:]
+			continue
+		}
+		size := int(binary.LittleEndian.Uint64(entry[i+1 : i+9]))
+		fields[name] = string(entry[i+9 : i+9+size])
+		entry = entry[i+10+size:]
+	}
+	return fields
+}
+
+func listenJournal(t *testing.T) (*net.UnixConn, string) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "socket")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	assert.Nil(t, err)
+	return ln, addr
+}
+
+func TestJournaldEntry(t *testing.T) {
+	ln, addr := listenJournal(t)
+	defer ln.Close()
+
+	j := NewJournald()
+	assert.Nil(t, j.Init(fmt.Sprintf(`{"addr":"%s","identifier":"shop","fields":{"environment":"test"},"level":6}`, addr)))
+	defer j.Destroy()
+	assert.Nil(t, j.WriteMsg(&LogMsg{Level: LevelDebug, Msg: "filtered", When: time.Now()}))
+	assert.Nil(t, j.WriteMsg(&LogMsg{
+		Level:      LevelError,
+		Msg:        "boom\n\tat main.go:13",
+		When:       time.Now(),
+		FilePath:   "/src/main.go",
+		LineNumber: 13,
+		Prefix:     "api",
+		Fields:     map[string]interface{}{"order-id": 7, "_hidden": "x", "42": "dropped"},
+	}))
+
+	buf := make([]byte, 4096)
+	ln.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := ln.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"MESSAGE":           "api boom\n\tat main.go:13",
+		"PRIORITY":          "3",
+		"SYSLOG_IDENTIFIER": "shop",
+		"CODE_FILE":         "/src/main.go",
+		"CODE_LINE":         "13",
+		"ENVIRONMENT":       "test",
+		"ORDER_ID":          "7",
+		"HIDDEN":            "x",
+	}, parseJournalEntry(t, buf[:n]))
+}
+
+func TestJournaldMemfd(t *testing.T) {
+	ln, addr := listenJournal(t)
+	defer ln.Close()
+
+	j := NewJournald()
+	assert.Nil(t, j.Init(fmt.Sprintf(`{"addr":"%s"}`, addr)))
+	defer j.Destroy()
+	msg := strings.Repeat("x", 1<<20)
+	assert.Nil(t, j.WriteMsg(&LogMsg{Level: LevelInfo, Msg: msg, When: time.Now()}))
+
+	oob := make([]byte, unix.CmsgSpace(4))
+	ln.SetReadDeadline(time.Now().Add(time.Second))
+	n, oobn, _, _, err := ln.ReadMsgUnix(nil, oob)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	assert.Nil(t, err)
+	if !assert.Equal(t, 1, len(msgs)) {
+		return
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	assert.Nil(t, err)
+	file := os.NewFile(uintptr(fds[0]), "memfd")
+	defer file.Close()
+	// the descriptor shares the offset left by the writer
+	_, err = file.Seek(0, 0)
+	assert.Nil(t, err)
+	entry, err := ioutil.ReadAll(file)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, parseJournalEntry(t, entry)["MESSAGE"])
+}
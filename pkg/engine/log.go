@@ -38,6 +38,7 @@ package engine
 //	log.Critical("critical")
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -76,6 +77,13 @@ const (
 	AdapterRamChandra = "ramchandra"
 	AdapterSlack      = "slack"
 	AdapterAliLS      = "alils"
+	AdapterSyslog     = "syslog"
+	AdapterJournald   = "journald" // linux only
+	AdapterGELF       = "gelf"
+	AdapterLoki       = "loki"
+	AdapterOTLP       = "otlp"
+	AdapterSplunk     = "splunk"
+	AdapterHTTP       = "http"
 )
 
 // Legacy log level constants to ensure backwards compatibility.
@@ -99,6 +107,15 @@ type Logger interface {
 var adapters = make(map[string]newLoggerFunc)
 var levelPrefix = [LevelDebug + 1]string{"[M]", "[A]", "[C]", "[E]", "[W]", "[N]", "[I]", "[D]"}
 
+// LevelName returns the lowercase name of level, like "error", or "" for
+// an unknown level.
+func LevelName(level int) string {
+	if level < 0 || level >= len(levelNames) {
+		return ""
+	}
+	return levelNames[level]
+}
+
 // Register makes a log provide available by the provided name.
 // If Register is called twice with the same name or if driver is nil,
 // it panics.
@@ -295,6 +312,10 @@ func (bl *BhojpurLogger) writeMsg(lm *LogMsg) error {
 	}
 	lm.FilePath = file
 	lm.LineNumber = line
+	// the logger prefix, unless the message brings its own
+	if lm.Prefix == "" {
+		lm.Prefix = bl.prefix
+	}
 
 	lm.enableFullFilePath = bl.enableFullFilePath
 	lm.enableFuncCallDepth = bl.enableFuncCallDepth
@@ -314,6 +335,8 @@ func (bl *BhojpurLogger) writeMsg(lm *LogMsg) error {
 		logM.FilePath = lm.FilePath
 		logM.LineNumber = lm.LineNumber
 		logM.Prefix = lm.Prefix
+		logM.Fields = lm.Fields
+		logM.Context = lm.Context
 		if bl.outputs != nil {
 			bl.msgChan <- lm
 		} else {
@@ -352,7 +375,8 @@ func (bl *BhojpurLogger) EnableFuncCallDepth(b bool) {
 	bl.enableFuncCallDepth = b
 }
 
-// set prefix
+// SetPrefix sets the prefix of the messages that have none of their own.
+// Adapters print it before the message, or send it as a field or label.
 func (bl *BhojpurLogger) SetPrefix(s string) {
 	bl.prefix = s
 }
@@ -566,6 +590,43 @@ func (bl *BhojpurLogger) Trace(format string, v ...interface{}) {
 	bl.writeMsg(lm)
 }
 
+// LogFields logs a message at the given level with structured fields.
+// Adapters with structured output, such as syslog, send the fields
+// apart from the message; the others ignore them.
+func (bl *BhojpurLogger) LogFields(level int, fields map[string]interface{}, format string, v ...interface{}) {
+	if level > bl.level {
+		return
+	}
+	lm := &LogMsg{
+		Level:  level,
+		Msg:    format,
+		When:   time.Now(),
+		Args:   v,
+		Fields: fields,
+	}
+
+	bl.writeMsg(lm)
+}
+
+// LogContext logs a message with structured fields and the context of
+// the request it belongs to. Adapters such as otlp take the active trace
+// span from ctx.
+func (bl *BhojpurLogger) LogContext(ctx context.Context, level int, fields map[string]interface{}, format string, v ...interface{}) {
+	if level > bl.level {
+		return
+	}
+	lm := &LogMsg{
+		Level:   level,
+		Msg:     format,
+		When:    time.Now(),
+		Args:    v,
+		Fields:  fields,
+		Context: ctx,
+	}
+
+	bl.writeMsg(lm)
+}
+
 // Flush flush all chan data.
 func (bl *BhojpurLogger) Flush() {
 	if bl.asynchronous {
@@ -577,6 +638,32 @@ func (bl *BhojpurLogger) Flush() {
 	bl.flush()
 }
 
+// ConnStats returns the delivery counters of the conn adapter.
+// The second value is false when no conn adapter is set.
+func (bl *BhojpurLogger) ConnStats() (ConnStats, bool) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	for _, l := range bl.outputs {
+		if cw, ok := l.Logger.(*connWriter); ok && l.name == AdapterConn {
+			return cw.Stats(), true
+		}
+	}
+	return ConnStats{}, false
+}
+
+// Adapter returns the adapter set under adapterName, for the adapter
+// packages to reach their own settings and counters.
+func (bl *BhojpurLogger) Adapter(adapterName string) (Logger, bool) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	for _, l := range bl.outputs {
+		if l.name == adapterName {
+			return l.Logger, true
+		}
+	}
+	return nil, false
+}
+
 // Close close logger, flush all chan data and destroy all adapters in BhojpurLogger.
 func (bl *BhojpurLogger) Close() {
 	if bl.asynchronous {
@@ -706,67 +793,94 @@ func SetLogger(adapter string, config ...string) error {
 
 // Emergency logs a message at emergency level.
 func Emergency(f interface{}, v ...interface{}) {
-	bhojpurLogger.Emergency(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Emergency(format, args...)
 }
 
 // Alert logs a message at alert level.
 func Alert(f interface{}, v ...interface{}) {
-	bhojpurLogger.Alert(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Alert(format, args...)
 }
 
 // Critical logs a message at critical level.
 func Critical(f interface{}, v ...interface{}) {
-	bhojpurLogger.Critical(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Critical(format, args...)
 }
 
 // Error logs a message at error level.
 func Error(f interface{}, v ...interface{}) {
-	bhojpurLogger.Error(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Error(format, args...)
 }
 
 // Warning logs a message at warning level.
 func Warning(f interface{}, v ...interface{}) {
-	bhojpurLogger.Warn(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Warn(format, args...)
 }
 
 // Warn compatibility alias for Warning()
 func Warn(f interface{}, v ...interface{}) {
-	bhojpurLogger.Warn(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Warn(format, args...)
 }
 
 // Notice logs a message at notice level.
 func Notice(f interface{}, v ...interface{}) {
-	bhojpurLogger.Notice(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Notice(format, args...)
 }
 
 // Informational logs a message at info level.
 func Informational(f interface{}, v ...interface{}) {
-	bhojpurLogger.Info(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Info(format, args...)
 }
 
 // Info compatibility alias for Warning()
 func Info(f interface{}, v ...interface{}) {
-	bhojpurLogger.Info(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Info(format, args...)
 }
 
 // Debug logs a message at debug level.
 func Debug(f interface{}, v ...interface{}) {
-	bhojpurLogger.Debug(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Debug(format, args...)
 }
 
 // Trace logs a message at trace level.
 // compatibility alias for Warning()
 func Trace(f interface{}, v ...interface{}) {
-	bhojpurLogger.Trace(formatLog(f, v...))
+	format, args := formatLog(f, v...)
+	bhojpurLogger.Trace(format, args...)
+}
+
+// LogFields logs a message with structured fields at the given level.
+func LogFields(level int, fields map[string]interface{}, f interface{}, v ...interface{}) {
+	format, args := formatLog(f, v...)
+	bhojpurLogger.LogFields(level, fields, format, args...)
+}
+
+// LogContext logs a message with structured fields and its request context.
+func LogContext(ctx context.Context, level int, fields map[string]interface{}, f interface{}, v ...interface{}) {
+	format, args := formatLog(f, v...)
+	bhojpurLogger.LogContext(ctx, level, fields, format, args...)
 }
 
-func formatLog(f interface{}, v ...interface{}) string {
+// formatLog returns the format and arguments of a message logged through
+// the package functions. The format is kept apart from its arguments so
+// that adapters see the same template for every call, as they do for the
+// BhojpurLogger methods.
+func formatLog(f interface{}, v ...interface{}) (string, []interface{}) {
 	var msg string
 	switch f.(type) {
 	case string:
 		msg = f.(string)
 		if len(v) == 0 {
-			return msg
+			return msg, nil
 		}
 		if !strings.Contains(msg, "%") {
 			// do not contain format char
@@ -775,9 +889,9 @@ func formatLog(f interface{}, v ...interface{}) string {
 	default:
 		msg = fmt.Sprint(f)
 		if len(v) == 0 {
-			return msg
+			return msg, nil
 		}
 		msg += strings.Repeat(" %v", len(v))
 	}
-	return fmt.Sprintf(msg, v...)
+	return msg, v
 }
@@ -21,6 +21,7 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
 	"fmt"
 	"path"
 	"time"
@@ -34,19 +35,24 @@ type LogMsg struct {
 	LineNumber          int
 	Args                []interface{}
 	Prefix              string
+	Fields              map[string]interface{} // structured data, see LogFields
+	Context             context.Context        // request context, see LogContext
 	enableFullFilePath  bool
 	enableFuncCallDepth bool
 }
 
-// OldStyleFormat you should never invoke this
-func (lm *LogMsg) OldStyleFormat() string {
-	msg := lm.Msg
-
+// Message returns Msg with Args applied.
+func (lm *LogMsg) Message() string {
 	if len(lm.Args) > 0 {
-		lm.Msg = fmt.Sprintf(lm.Msg, lm.Args...)
+		return fmt.Sprintf(lm.Msg, lm.Args...)
 	}
+	return lm.Msg
+}
 
-	msg = lm.Prefix + " " + msg
+// OldStyleFormat you should never invoke this
+func (lm *LogMsg) OldStyleFormat() string {
+	// lm is shared by all adapters and must not be changed here
+	msg := lm.Prefix + " " + lm.Message()
 
 	if lm.enableFuncCallDepth {
 		filePath := lm.FilePath
@@ -47,4 +47,11 @@ func TestLogMsg_OldStyleFormat(t *testing.T) {
 
 	res = lg.OldStyleFormat()
 	assert.Equal(t, "[D] [/user/home/main.go:13] Cus Hello, world", res)
+
+	lg.Msg = "Hello, %s"
+	lg.Args = []interface{}{"world"}
+	lg.enableFuncCallDepth = false
+	assert.Equal(t, "[D] Cus Hello, world", lg.OldStyleFormat())
+	assert.Equal(t, "[D] Cus Hello, world", lg.OldStyleFormat())
+	assert.Equal(t, "Hello, %s", lg.Msg)
 }
@@ -22,6 +22,7 @@ package engine
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,3 +32,34 @@ func TestBhojpurLogger_DelLogger(t *testing.T) {
 	l := GetLogger(prefix)
 	assert.NotNil(t, l)
 }
+
+// prefixRecorder records the prefix of every message it gets.
+type prefixRecorder struct {
+	prefixes []string
+}
+
+func (r *prefixRecorder) Init(config string) error    { return nil }
+func (r *prefixRecorder) Destroy()                    {}
+func (r *prefixRecorder) Flush()                      {}
+func (r *prefixRecorder) SetFormatter(f LogFormatter) {}
+
+func (r *prefixRecorder) WriteMsg(lm *LogMsg) error {
+	r.prefixes = append(r.prefixes, lm.Prefix)
+	return nil
+}
+
+func TestBhojpurLogger_SetPrefix(t *testing.T) {
+	rec := &prefixRecorder{}
+	Register("test-prefix", func() Logger { return rec })
+	log := NewLogger()
+	assert.Nil(t, log.SetLogger("test-prefix"))
+
+	log.Error("no prefix yet")
+	log.SetPrefix("api")
+	log.Error("logger prefix")
+	log.writeMsg(&LogMsg{Level: LevelError, Msg: "own prefix", Prefix: "db", When: time.Now()})
+	log.SetPrefix("")
+	log.Error("prefix cleared")
+
+	assert.Equal(t, []string{"", "api", "db", ""}, rec.prefixes)
+}
@@ -44,6 +44,13 @@ func (lg *logWriter) writeln(msg string) (int, error) {
 	return n, err
 }
 
+func (lg *logWriter) write(b []byte) (int, error) {
+	lg.Lock()
+	n, err := lg.writer.Write(b)
+	lg.Unlock()
+	return n, err
+}
+
 const (
 	y1  = `0123456789`
 	y2  = `0123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789`
@@ -0,0 +1,262 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiStreamIdle is how long the newest timestamp of a stream is kept
+// after its last push.
+const lokiStreamIdle = time.Hour
+
+// lokiLast is the newest timestamp pushed to a stream, and when.
+type lokiLast struct {
+	ts   int64
+	sent time.Time
+}
+
+// lokiWriter implements LoggerInterface.
+// It pushes batches of messages to the Grafana Loki push API.
+type lokiWriter struct {
+	formatter LogFormatter
+	sender    *httpSender
+
+	lock   sync.Mutex
+	lastTS map[string]lokiLast // newest timestamp pushed per stream
+
+	Formatter   string            `json:"formatter"`
+	Level       int               `json:"level"`
+	URL         string            `json:"url"`
+	Labels      map[string]string `json:"labels"`
+	LevelLabel  string            `json:"levelLabel"`
+	PrefixLabel string            `json:"prefixLabel"`
+	Tenant      string            `json:"tenant"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	HTTPOptions
+}
+
+// NewLoki creates a Loki writer returning as LoggerInterface.
+func NewLoki() Logger {
+	l := &lokiWriter{
+		Level:       LevelDebug,
+		LevelLabel:  "level",
+		PrefixLabel: "prefix",
+		HTTPOptions: defaultHTTPOptions(),
+		lastTS:      make(map[string]lokiLast),
+	}
+	l.Gzip = true
+	l.formatter = l
+	return l
+}
+
+// Init initializes the Loki writer with json config.
+// url is the Loki base url; the push path is added when missing.
+// Each message gets the static labels, plus its level and prefix under
+// the levelLabel and prefixLabel names, which "-" turns off. Durations
+// are in milliseconds:
+//
+//	{
+//	"url":"https://loki.bhojpur.net",
+//	"labels":{"app":"shop","env":"production"},
+//	"tenant":"team-a",
+//	"username":"shop",
+//	"password":"secret",
+//	"batchSize":500,
+//	"batchWait":1000,
+//	"timeout":10000,
+//	"level":6
+//	}
+func (l *lokiWriter) Init(config string) error {
+	if err := json.Unmarshal([]byte(config), l); err != nil {
+		return err
+	}
+	if len(l.Formatter) > 0 {
+		fmtr, ok := GetFormatter(l.Formatter)
+		if !ok {
+			return errors.New(fmt.Sprintf("the formatter with name: %s not found", l.Formatter))
+		}
+		l.formatter = fmtr
+	}
+	if l.URL == "" {
+		return errors.New("loki url is required")
+	}
+	if !strings.HasSuffix(l.URL, lokiPushPath) {
+		l.URL = strings.TrimSuffix(l.URL, "/") + lokiPushPath
+	}
+	opts := l.HTTPOptions
+	opts.Headers = make(map[string]string, len(l.Headers)+2)
+	for k, v := range l.Headers {
+		opts.Headers[k] = v
+	}
+	if l.Tenant != "" {
+		opts.Headers["X-Scope-OrgID"] = l.Tenant
+	}
+	if l.Username != "" {
+		opts.Headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(l.Username+":"+l.Password))
+	}
+	l.Destroy()
+	var err error
+	l.sender, err = newHTTPSender("lokiWriter", http.MethodPost, l.URL, "application/json", opts, l.encode, l.pushed)
+	return err
+}
+
+func (l *lokiWriter) SetFormatter(f LogFormatter) {
+	l.formatter = f
+}
+
+// Format returns the log line. Level and prefix are labels.
+func (l *lokiWriter) Format(lm *LogMsg) string {
+	return lm.Message()
+}
+
+// WriteMsg queues the message for the next push.
+func (l *lokiWriter) WriteMsg(lm *LogMsg) error {
+	if lm.Level > l.Level {
+		return nil
+	}
+	l.sender.add(lm, l.formatter.Format(lm))
+	return nil
+}
+
+// labels returns the stream labels of lm.
+func (l *lokiWriter) labels(lm *LogMsg) map[string]string {
+	labels := make(map[string]string, len(l.Labels)+2)
+	for k, v := range l.Labels {
+		labels[k] = v
+	}
+	if l.LevelLabel != "" && l.LevelLabel != "-" && lm.Level >= 0 && lm.Level < len(levelNames) {
+		labels[l.LevelLabel] = levelNames[lm.Level]
+	}
+	if l.PrefixLabel != "" && l.PrefixLabel != "-" && lm.Prefix != "" {
+		labels[l.PrefixLabel] = lm.Prefix
+	}
+	return labels
+}
+
+// lokiStream is one stream of a push request.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// streams groups entries by labels, keeping their order. Timestamps
+// never go backwards within a stream, as Loki rejects that. It also
+// returns the newest timestamp of each stream, which pushed records once
+// the push succeeds, so that a retried batch gets the same timestamps.
+func (l *lokiWriter) streams(entries []BatchEntry) ([]*lokiStream, map[string]int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var streams []*lokiStream
+	last := make(map[string]int64)
+	byKey := make(map[string]*lokiStream)
+	for i := range entries {
+		labels := l.labels(&entries[i].Msg)
+		key := lokiLabelsKey(labels)
+		s, ok := byKey[key]
+		if !ok {
+			s = &lokiStream{Stream: labels}
+			byKey[key] = s
+			streams = append(streams, s)
+		}
+		prev, ok := last[key]
+		if !ok {
+			prev = l.lastTS[key].ts
+		}
+		ts := entries[i].Msg.When.UnixNano()
+		if ts < prev {
+			ts = prev
+		}
+		last[key] = ts
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(ts, 10), entries[i].Text})
+	}
+	return streams, last
+}
+
+// pushed records the newest timestamps of a pushed batch and forgets the
+// streams idle for lokiStreamIdle. The batches are pushed one at a time,
+// so the timestamps are those encode sent.
+func (l *lokiWriter) pushed(entries []BatchEntry, res []byte) error {
+	_, last := l.streams(entries)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	for key, ts := range last {
+		l.lastTS[key] = lokiLast{ts: ts, sent: now}
+	}
+	for key, v := range l.lastTS {
+		if now.Sub(v.sent) > lokiStreamIdle {
+			delete(l.lastTS, key)
+		}
+	}
+	return nil
+}
+
+func lokiLabelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, labels[k])
+	}
+	return b.String()
+}
+
+// encode returns the push request of one batch.
+func (l *lokiWriter) encode(entries []BatchEntry) ([]byte, error) {
+	streams, _ := l.streams(entries)
+	return json.Marshal(map[string]interface{}{"streams": streams})
+}
+
+// Flush blocks until the queued messages are pushed.
+func (l *lokiWriter) Flush() {
+	if l.sender != nil {
+		l.sender.Flush()
+	}
+}
+
+// Destroy pushes the queued messages and stops the writer.
+func (l *lokiWriter) Destroy() {
+	if l.sender != nil {
+		l.sender.close()
+		l.sender = nil
+	}
+}
+
+func init() {
+	Register(AdapterLoki, NewLoki)
+}
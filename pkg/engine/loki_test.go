@@ -0,0 +1,159 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+func TestLokiPush(t *testing.T) {
+	var lock sync.Mutex
+	var pushes []lokiPush
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		assert.Equal(t, lokiPushPath, r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "team-a", r.Header.Get("X-Scope-OrgID"))
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "shop:secret", user+":"+pass)
+
+		zr, err := gzip.NewReader(r.Body)
+		assert.Nil(t, err)
+		var push lokiPush
+		assert.Nil(t, json.NewDecoder(zr).Decode(&push))
+		pushes = append(pushes, push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	l := NewLoki()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"url":"%s","labels":{"app":"shop"},"tenant":"team-a","username":"shop","password":"secret","batchSize":10,"batchWait":60000,"retryMin":10,"retryMax":20}`, srv.URL)))
+	defer l.Destroy()
+
+	now := time.Unix(1600546357, 0)
+	l.WriteMsg(&LogMsg{Level: LevelError, Msg: "first", When: now, Prefix: "api"})
+	l.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "other stream", When: now})
+	// older than the first one of its stream
+	l.WriteMsg(&LogMsg{Level: LevelError, Msg: "second", When: now.Add(-time.Second), Prefix: "api"})
+	l.Flush()
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []lokiPush{{Streams: []lokiStream{
+		{
+			Stream: map[string]string{"app": "shop", "level": "error", "prefix": "api"},
+			Values: [][2]string{{"1600546357000000000", "first"}, {"1600546357000000000", "second"}},
+		},
+		{
+			Stream: map[string]string{"app": "shop", "level": "info"},
+			Values: [][2]string{{"1600546357000000000", "other stream"}},
+		},
+	}}}, pushes)
+}
+
+func TestLokiRetryTimestamps(t *testing.T) {
+	var lock sync.Mutex
+	var pushes []lokiPush
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var push lokiPush
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&push))
+		pushes = append(pushes, push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	l := NewLoki()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"url":"%s","gzip":false,"batchSize":10,"batchWait":60000,"retryMin":1,"retryMax":1}`, srv.URL)))
+	defer l.Destroy()
+	lw := l.(*lokiWriter)
+	lw.lastTS["idle"] = lokiLast{ts: 1, sent: time.Now().Add(-2 * lokiStreamIdle)}
+
+	now := time.Unix(1600546357, 0)
+	l.WriteMsg(&LogMsg{Level: LevelError, Msg: "first", When: now.Add(-time.Second)})
+	l.WriteMsg(&LogMsg{Level: LevelError, Msg: "second", When: now})
+	l.Flush()
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 2, attempts)
+	// the failed attempt does not move the timestamps of the retry
+	assert.Equal(t, [][2]string{{"1600546356000000000", "first"}, {"1600546357000000000", "second"}}, pushes[0].Streams[0].Values)
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	assert.Equal(t, 1, len(lw.lastTS))
+	for _, last := range lw.lastTS {
+		assert.Equal(t, now.UnixNano(), last.ts)
+	}
+}
+
+func TestLokiBatchSize(t *testing.T) {
+	requests := make(chan int, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var push lokiPush
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&push))
+		requests <- len(push.Streams[0].Values)
+	}))
+	defer srv.Close()
+
+	l := NewLoki()
+	assert.Nil(t, l.Init(fmt.Sprintf(`{"url":"%s","gzip":false,"levelLabel":"-","batchSize":2,"batchWait":60000}`, srv.URL)))
+	for i := 0; i < 5; i++ {
+		l.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "msg", When: time.Now()})
+	}
+	// two full batches go out without waiting
+	assert.Equal(t, 2, <-requests)
+	assert.Equal(t, 2, <-requests)
+	l.Destroy()
+	assert.Equal(t, 1, <-requests)
+
+	assert.NotNil(t, NewLoki().Init(`{}`))
+}
@@ -22,6 +22,12 @@ package engine
 
 import (
 	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // A filesLogWriter manages several fileLogWriter
@@ -29,10 +35,39 @@ import (
 // means if the file name in configuration is project.log filesLogWriter will create project.error.log/project.debug.log
 // and write the error-level logs to project.error.log and write the debug-level logs to project.debug.log
 // the rotate attribute also  acts like fileLogWriter
+// Additional routes send the messages matching a level range, prefix or caller package
+// to their own file, each with its own formatter and rotation settings
 type multiFileLogWriter struct {
 	writers       [LevelDebug + 1 + 1]*fileLogWriter // the last one for fullLogWriter
 	fullLogWriter *fileLogWriter
-	Separate      []string `json:"separate"`
+	Separate      []string                 `json:"separate"`
+	Routes        []map[string]interface{} `json:"routes"`
+	routes        []*fileRoute
+	formatter     LogFormatter
+}
+
+// fileRoute writes the messages it matches into its own file.
+// Level and MinLevel bound the written levels, so "level":4 with the default
+// "minlevel":0 routes warnings and above.
+type fileRoute struct {
+	Level    int    `json:"level"`
+	MinLevel int    `json:"minlevel"`
+	Prefix   string `json:"prefix"`  // message prefix starts with Prefix
+	Package  string `json:"package"` // caller file is in Package, e.g. "pkg/db"
+	writer   *fileLogWriter
+}
+
+func (r *fileRoute) match(lm *LogMsg) bool {
+	if lm.Level < r.MinLevel || lm.Level > r.Level {
+		return false
+	}
+	if r.Prefix != "" && !strings.HasPrefix(lm.Prefix, r.Prefix) {
+		return false
+	}
+	if r.Package != "" && !strings.Contains(path.Dir(lm.FilePath)+"/", "/"+strings.Trim(r.Package, "/")+"/") {
+		return false
+	}
+	return true
 }
 
 var levelNames = [...]string{"emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"}
@@ -48,7 +83,13 @@ var levelNames = [...]string{"emergency", "alert", "critical", "error", "warning
 //	"rotate":true,
 //  	"perm":0600,
 //	"separate":["emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"],
+//	"routes":[
+//		{"filename":"logs/warn.log", "level":4},
+//		{"filename":"logs/db.log", "package":"pkg/db", "formatter":"json", "maxlines":1000}
+//	]
 //	}
+// route settings not given fall back to the top level ones, except the
+// filename, which every route needs for a file of its own
 
 func (f *multiFileLogWriter) Init(config string) error {
 
@@ -60,7 +101,7 @@ func (f *multiFileLogWriter) Init(config string) error {
 	f.fullLogWriter = writer
 	f.writers[LevelDebug+1] = writer
 
-	// unmarshal "separate" field to f.Separate
+	// unmarshal "separate" and "routes" fields
 	err = json.Unmarshal([]byte(config), f)
 	if err != nil {
 		return err
@@ -71,6 +112,9 @@ func (f *multiFileLogWriter) Init(config string) error {
 	if err != nil {
 		return err
 	}
+	delete(jsonMap, "separate")
+	delete(jsonMap, "routes")
+	files := map[string]bool{filepath.Clean(writer.Filename): true}
 
 	for i := LevelEmergency; i < LevelDebug+1; i++ {
 		for _, v := range f.Separate {
@@ -81,12 +125,61 @@ func (f *multiFileLogWriter) Init(config string) error {
 				writer = newFileWriter().(*fileLogWriter)
 				err := writer.Init(string(bs))
 				if err != nil {
+					f.Destroy()
 					return err
 				}
 				f.writers[i] = writer
+				files[filepath.Clean(writer.Filename)] = true
 			}
 		}
 	}
+
+	for _, r := range f.routes {
+		r.writer.Destroy()
+	}
+	f.routes = nil
+	if err := f.initRoutes(jsonMap, files); err != nil {
+		f.Destroy()
+		return err
+	}
+
+	if f.formatter != nil {
+		f.SetFormatter(f.formatter)
+	}
+	return nil
+}
+
+// initRoutes opens the file of every route. files holds the files
+// already written, which no route may share.
+func (f *multiFileLogWriter) initRoutes(jsonMap map[string]interface{}, files map[string]bool) error {
+	for i, r := range f.Routes {
+		name, _ := r["filename"].(string)
+		if name == "" {
+			return errors.New(fmt.Sprintf("multifile route %d has no filename", i))
+		}
+		if files[filepath.Clean(name)] {
+			return errors.New(fmt.Sprintf("multifile route %d writes to %s, which is already written", i, name))
+		}
+		files[filepath.Clean(name)] = true
+
+		routeMap := map[string]interface{}{}
+		for k, v := range jsonMap {
+			routeMap[k] = v
+		}
+		for k, v := range r {
+			routeMap[k] = v
+		}
+		bs, _ := json.Marshal(routeMap)
+		route := &fileRoute{Level: LevelDebug}
+		if err := json.Unmarshal(bs, route); err != nil {
+			return err
+		}
+		route.writer = newFileWriter().(*fileLogWriter)
+		if err := route.writer.Init(string(bs)); err != nil {
+			return err
+		}
+		f.routes = append(f.routes, route)
+	}
 	return nil
 }
 
@@ -94,16 +187,34 @@ func (f *multiFileLogWriter) Format(lm *LogMsg) string {
 	return lm.OldStyleFormat()
 }
 
+// SetFormatter sets the formatter of every file which has no formatter in its config.
 func (f *multiFileLogWriter) SetFormatter(fmt LogFormatter) {
-	f.fullLogWriter.SetFormatter(f)
+	f.formatter = fmt
+	for _, w := range f.fileWriters() {
+		if len(w.Formatter) == 0 {
+			w.SetFormatter(fmt)
+		}
+	}
 }
 
-func (f *multiFileLogWriter) Destroy() {
+// fileWriters returns all file writers managed by f.
+func (f *multiFileLogWriter) fileWriters() []*fileLogWriter {
+	res := make([]*fileLogWriter, 0, len(f.writers)+len(f.routes))
 	for i := 0; i < len(f.writers); i++ {
 		if f.writers[i] != nil {
-			f.writers[i].Destroy()
+			res = append(res, f.writers[i])
 		}
 	}
+	for _, r := range f.routes {
+		res = append(res, r.writer)
+	}
+	return res
+}
+
+func (f *multiFileLogWriter) Destroy() {
+	for _, w := range f.fileWriters() {
+		w.Destroy()
+	}
 }
 
 func (f *multiFileLogWriter) WriteMsg(lm *LogMsg) error {
@@ -117,14 +228,17 @@ func (f *multiFileLogWriter) WriteMsg(lm *LogMsg) error {
 			}
 		}
 	}
+	for _, r := range f.routes {
+		if r.match(lm) {
+			r.writer.WriteMsg(lm)
+		}
+	}
 	return nil
 }
 
 func (f *multiFileLogWriter) Flush() {
-	for i := 0; i < len(f.writers); i++ {
-		if f.writers[i] != nil {
-			f.writers[i].Flush()
-		}
+	for _, w := range f.fileWriters() {
+		w.Flush()
 	}
 }
 
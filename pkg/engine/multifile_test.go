@@ -22,10 +22,13 @@ package engine
 
 import (
 	"bufio"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestFiles_1(t *testing.T) {
@@ -82,3 +85,101 @@ func TestFiles_1(t *testing.T) {
 	}
 
 }
+
+type routeFormatter struct{}
+
+func (r *routeFormatter) Format(lm *LogMsg) string {
+	return "route " + lm.Msg + "\n"
+}
+
+func TestFilesRoutes(t *testing.T) {
+	RegisterFormatter("test-route", &routeFormatter{})
+	log := NewLogger(10000)
+	log.SetPrefix("db")
+	log.SetLogFuncCallDepth(2)
+	err := log.SetLogger(AdapterMultiFile, `{"filename":"test_route.log","routes":[
+		{"filename":"test_route_warn.log","level":4},
+		{"filename":"test_route_db.log","prefix":"db","minlevel":3,"level":6,"formatter":"test-route"},
+		{"filename":"test_route_pkg.log","package":"pkg/engine"},
+		{"filename":"test_route_other.log","package":"pkg/other"}]}`)
+	assert.Nil(t, err)
+	log.Debug("debug")
+	log.Informational("info")
+	log.Warning("warning")
+	log.Error("error")
+	log.Critical("critical")
+	log.Close()
+
+	expected := map[string][]string{
+		"test_route.log":       {"debug", "info", "warning", "error", "critical"},
+		"test_route_warn.log":  {"warning", "error", "critical"},
+		"test_route_db.log":    {"route info", "route warning", "route error"},
+		"test_route_pkg.log":   {"debug", "info", "warning", "error", "critical"},
+		"test_route_other.log": {},
+	}
+	for file, msgs := range expected {
+		content, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		os.Remove(file)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(msgs) == 0 {
+			assert.Equal(t, "", strings.TrimSpace(string(content)), file)
+		} else {
+			if !assert.Equal(t, len(msgs), len(lines), file) {
+				continue
+			}
+			for i, msg := range msgs {
+				assert.True(t, strings.HasSuffix(lines[i], msg), file+": "+lines[i])
+			}
+		}
+	}
+}
+
+func TestFilesSetFormatter(t *testing.T) {
+	w := newFilesWriter().(*multiFileLogWriter)
+	fmtr := &PatternLogFormatter{Pattern: "%m"}
+	w.SetFormatter(fmtr)
+	assert.Nil(t, w.Init(`{"filename":"test_fmt.log","separate":["error"]}`))
+	assert.Equal(t, fmtr, w.fullLogWriter.formatter)
+	assert.Equal(t, fmtr, w.writers[LevelError].formatter)
+	w.Destroy()
+	os.Remove("test_fmt.log")
+	os.Remove("test_fmt.error.log")
+}
+
+func TestFilesRoutesConfig(t *testing.T) {
+	defer func() {
+		for _, f := range []string{"test_routes.log", "test_routes.error.log", "test_routes_a.log"} {
+			os.Remove(f)
+		}
+	}()
+
+	// every route needs a file of its own
+	for _, routes := range []string{
+		`[{"level":3}]`,
+		`[{"filename":"test_routes.log"}]`,
+		`[{"filename":"test_routes.error.log"}]`,
+		`[{"filename":"test_routes_a.log"},{"filename":"test_routes_a.log","prefix":"db"}]`,
+	} {
+		w := newFilesWriter()
+		assert.NotNil(t, w.Init(`{"filename":"test_routes.log","separate":["error"],"routes":`+routes+`}`), routes)
+	}
+
+	// a failing route closes the files opened before it
+	w := newFilesWriter().(*multiFileLogWriter)
+	assert.NotNil(t, w.Init(`{"filename":"test_routes.log","routes":[{"filename":"test_routes_a.log"},{"filename":"test_routes_b.log","formatter":"no-such"}]}`))
+	if assert.Len(t, w.routes, 1) {
+		_, err := w.routes[0].writer.fileWriter.Write([]byte("closed"))
+		assert.NotNil(t, err)
+	}
+	_, err := w.fullLogWriter.fileWriter.Write([]byte("closed"))
+	assert.NotNil(t, err)
+
+	// Init again replaces the routes
+	config := `{"filename":"test_routes.log","routes":[{"filename":"test_routes_a.log","level":3}]}`
+	w = newFilesWriter().(*multiFileLogWriter)
+	assert.Nil(t, w.Init(config))
+	assert.Nil(t, w.Init(config))
+	assert.Len(t, w.routes, 1)
+	w.Destroy()
+}
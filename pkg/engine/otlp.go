@@ -0,0 +1,354 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	common "go.opentelemetry.io/proto/otlp/common/v1"
+	otlplogs "go.opentelemetry.io/proto/otlp/logs/v1"
+	resource "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLP transport protocols.
+const (
+	OTLPGRPC = "grpc"
+	OTLPHTTP = "http"
+)
+
+// SpanExtractor returns the trace and span ids of the span carried by
+// ctx, if any.
+type SpanExtractor func(ctx context.Context) (traceID [16]byte, spanID [8]byte, ok bool)
+
+var spanExtractor SpanExtractor
+
+// RegisterSpanExtractor sets how the otlp adapter finds the active span
+// in the context of a message logged with LogContext. With OpenTelemetry:
+//
+//	RegisterSpanExtractor(func(ctx context.Context) ([16]byte, [8]byte, bool) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		return [16]byte(sc.TraceID()), [8]byte(sc.SpanID()), sc.IsValid()
+//	})
+func RegisterSpanExtractor(fn SpanExtractor) {
+	spanExtractor = fn
+}
+
+// otlpSeverities maps the RFC5424 levels to OTLP severity numbers.
+var otlpSeverities = [LevelDebug + 1]otlplogs.SeverityNumber{
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_FATAL,  // Emergency
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_ERROR3, // Alert
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_ERROR2, // Critical
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_ERROR,  // Error
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_WARN,   // Warning
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_INFO2,  // Notice
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_INFO,   // Informational
+	otlplogs.SeverityNumber_SEVERITY_NUMBER_DEBUG,  // Debug
+}
+
+// otlpWriter implements LoggerInterface.
+// It exports batches of log records over OTLP/gRPC or OTLP/HTTP.
+type otlpWriter struct {
+	formatter LogFormatter
+	batcher   *Batcher // grpc
+	conn      *grpc.ClientConn
+	client    collogs.LogsServiceClient
+	sender    *httpSender // http
+	resource  *resource.Resource
+
+	Formatter   string            `json:"formatter"`
+	Level       int               `json:"level"`
+	Protocol    string            `json:"protocol"`
+	Endpoint    string            `json:"endpoint"`
+	Insecure    bool              `json:"insecure"`
+	ServiceName string            `json:"serviceName"`
+	Resource    map[string]string `json:"resource"`
+	HTTPOptions
+}
+
+// NewOTLP creates an OTLP writer returning as LoggerInterface.
+func NewOTLP() Logger {
+	o := &otlpWriter{
+		Level:       LevelDebug,
+		Protocol:    OTLPGRPC,
+		HTTPOptions: defaultHTTPOptions(),
+	}
+	o.formatter = o
+	return o
+}
+
+// Init initializes the OTLP writer with json config.
+// endpoint is host:port for grpc, with TLS unless insecure is set, and
+// the full url for http. Its defaults are the collector defaults.
+// resource holds the resource attributes, serviceName is a shortcut for
+// service.name. Durations are in milliseconds:
+//
+//	{
+//	"protocol":"grpc",
+//	"endpoint":"otel-collector:4317",
+//	"insecure":true,
+//	"headers":{"x-tenant":"team-a"},
+//	"serviceName":"shop",
+//	"resource":{"deployment.environment":"production"},
+//	"batchSize":512,
+//	"batchWait":1000,
+//	"level":6
+//	}
+func (o *otlpWriter) Init(config string) error {
+	if err := json.Unmarshal([]byte(config), o); err != nil {
+		return err
+	}
+	if len(o.Formatter) > 0 {
+		fmtr, ok := GetFormatter(o.Formatter)
+		if !ok {
+			return errors.New(fmt.Sprintf("the formatter with name: %s not found", o.Formatter))
+		}
+		o.formatter = fmtr
+	}
+
+	attrs := make(map[string]interface{}, len(o.Resource)+1)
+	for k, v := range o.Resource {
+		attrs[k] = v
+	}
+	if o.ServiceName != "" {
+		attrs["service.name"] = o.ServiceName
+	}
+	o.resource = &resource.Resource{Attributes: otlpAttributes(attrs)}
+
+	o.close()
+	switch o.Protocol {
+	case OTLPGRPC:
+		if o.Endpoint == "" {
+			o.Endpoint = "localhost:4317"
+		}
+		opts := []grpc.DialOption{grpc.WithInsecure()}
+		if !o.Insecure {
+			cfg, err := o.ClientConfig(o.Endpoint)
+			if err != nil {
+				return err
+			}
+			opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(cfg))}
+		}
+		if o.Gzip {
+			opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor("gzip")))
+		}
+		conn, err := grpc.Dial(o.Endpoint, opts...)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+		o.client = collogs.NewLogsServiceClient(conn)
+		o.batcher = NewBatcher("otlpWriter", o.BatchOptions, o.export)
+	case OTLPHTTP:
+		if o.Endpoint == "" {
+			o.Endpoint = "http://localhost:4318/v1/logs"
+		}
+		sender, err := newHTTPSender("otlpWriter", http.MethodPost, o.Endpoint, "application/x-protobuf", o.HTTPOptions, o.encode, nil)
+		if err != nil {
+			return err
+		}
+		o.sender = sender
+	default:
+		return errors.New(fmt.Sprintf("unknown otlp protocol: %s", o.Protocol))
+	}
+	return nil
+}
+
+func (o *otlpWriter) SetFormatter(f LogFormatter) {
+	o.formatter = f
+}
+
+// Format returns the record body.
+func (o *otlpWriter) Format(lm *LogMsg) string {
+	return lm.Message()
+}
+
+// WriteMsg queues the message for the next export.
+func (o *otlpWriter) WriteMsg(lm *LogMsg) error {
+	if lm.Level > o.Level {
+		return nil
+	}
+	if o.sender != nil {
+		o.sender.add(lm, o.formatter.Format(lm))
+	} else {
+		o.batcher.Add(lm, o.formatter.Format(lm))
+	}
+	return nil
+}
+
+// record converts a message to an OTLP log record.
+func (o *otlpWriter) record(e *BatchEntry) *otlplogs.LogRecord {
+	lm := &e.Msg
+	r := &otlplogs.LogRecord{
+		TimeUnixNano: uint64(lm.When.UnixNano()),
+		Body:         &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: e.Text}},
+	}
+	if lm.Level >= 0 && lm.Level <= LevelDebug {
+		r.SeverityNumber = otlpSeverities[lm.Level]
+		r.SeverityText = levelNames[lm.Level]
+	}
+
+	attrs := make(map[string]interface{}, len(lm.Fields)+3)
+	for k, v := range lm.Fields {
+		attrs[k] = v
+	}
+	if lm.FilePath != "" {
+		attrs["code.filepath"] = lm.FilePath
+		attrs["code.lineno"] = lm.LineNumber
+	}
+	if lm.Prefix != "" {
+		attrs["log.prefix"] = lm.Prefix
+	}
+	r.Attributes = otlpAttributes(attrs)
+
+	if lm.Context != nil && spanExtractor != nil {
+		if traceID, spanID, ok := spanExtractor(lm.Context); ok {
+			r.TraceId = traceID[:]
+			r.SpanId = spanID[:]
+		}
+	}
+	return r
+}
+
+// otlpAttributes converts attrs to key values sorted by key.
+func otlpAttributes(attrs map[string]interface{}) []*common.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]*common.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, &common.KeyValue{Key: k, Value: otlpValue(attrs[k])})
+	}
+	return kvs
+}
+
+func otlpValue(v interface{}) *common.AnyValue {
+	switch v := v.(type) {
+	case string:
+		return &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &common.AnyValue{Value: &common.AnyValue_BoolValue{BoolValue: v}}
+	case int:
+		return &common.AnyValue{Value: &common.AnyValue_IntValue{IntValue: int64(v)}}
+	case int32:
+		return &common.AnyValue{Value: &common.AnyValue_IntValue{IntValue: int64(v)}}
+	case int64:
+		return &common.AnyValue{Value: &common.AnyValue_IntValue{IntValue: v}}
+	case float32:
+		return &common.AnyValue{Value: &common.AnyValue_DoubleValue{DoubleValue: float64(v)}}
+	case float64:
+		return &common.AnyValue{Value: &common.AnyValue_DoubleValue{DoubleValue: v}}
+	case []byte:
+		return &common.AnyValue{Value: &common.AnyValue_BytesValue{BytesValue: v}}
+	default:
+		return &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: fmt.Sprint(v)}}
+	}
+}
+
+// request builds the export request for entries.
+func (o *otlpWriter) request(entries []BatchEntry) *collogs.ExportLogsServiceRequest {
+	records := make([]*otlplogs.LogRecord, len(entries))
+	for i := range entries {
+		records[i] = o.record(&entries[i])
+	}
+	return &collogs.ExportLogsServiceRequest{
+		ResourceLogs: []*otlplogs.ResourceLogs{{
+			Resource: o.resource,
+			InstrumentationLibraryLogs: []*otlplogs.InstrumentationLibraryLogs{{
+				InstrumentationLibrary: &common.InstrumentationLibrary{Name: "github.com/bhojpur/logger"},
+				Logs:                   records,
+			}},
+		}},
+	}
+}
+
+// export sends one batch over grpc.
+func (o *otlpWriter) export(entries []BatchEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(o.Timeout)*time.Millisecond)
+	defer cancel()
+	if len(o.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(o.Headers))
+	}
+	_, err := o.client.Export(ctx, o.request(entries))
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+		return &retryError{err: err}
+	default:
+		return err
+	}
+}
+
+// encode returns the OTLP/HTTP body of one batch.
+func (o *otlpWriter) encode(entries []BatchEntry) ([]byte, error) {
+	return proto.Marshal(o.request(entries))
+}
+
+// close stops the batcher or sender and closes the grpc connection.
+func (o *otlpWriter) close() {
+	if o.batcher != nil {
+		o.batcher.Close()
+		o.batcher = nil
+	}
+	if o.sender != nil {
+		o.sender.close()
+		o.sender = nil
+	}
+	if o.conn != nil {
+		o.conn.Close()
+		o.conn, o.client = nil, nil
+	}
+}
+
+// Flush blocks until the queued records are exported.
+func (o *otlpWriter) Flush() {
+	if o.batcher != nil {
+		o.batcher.Flush()
+	}
+	if o.sender != nil {
+		o.sender.Flush()
+	}
+}
+
+// Destroy exports the queued records and closes the connection.
+func (o *otlpWriter) Destroy() {
+	o.close()
+}
+
+func init() {
+	Register(AdapterOTLP, NewOTLP)
+}
@@ -0,0 +1,155 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	common "go.opentelemetry.io/proto/otlp/common/v1"
+	otlplogs "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+type spanKey struct{}
+
+type testLogsServer struct {
+	collogs.UnimplementedLogsServiceServer
+	requests chan *collogs.ExportLogsServiceRequest
+	tenants  chan []string
+}
+
+func (s *testLogsServer) Export(ctx context.Context, req *collogs.ExportLogsServiceRequest) (*collogs.ExportLogsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.tenants <- md.Get("x-tenant")
+	s.requests <- req
+	return &collogs.ExportLogsServiceResponse{}, nil
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := grpc.NewServer()
+	logs := &testLogsServer{
+		requests: make(chan *collogs.ExportLogsServiceRequest, 1),
+		tenants:  make(chan []string, 1),
+	}
+	collogs.RegisterLogsServiceServer(srv, logs)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	RegisterSpanExtractor(func(ctx context.Context) ([16]byte, [8]byte, bool) {
+		span, ok := ctx.Value(spanKey{}).([24]byte)
+		var traceID [16]byte
+		var spanID [8]byte
+		copy(traceID[:], span[:16])
+		copy(spanID[:], span[16:])
+		return traceID, spanID, ok
+	})
+	defer RegisterSpanExtractor(nil)
+
+	o := NewOTLP()
+	assert.Nil(t, o.Init(fmt.Sprintf(`{"endpoint":"%s","insecure":true,"gzip":true,"headers":{"x-tenant":"team-a"},"serviceName":"shop","resource":{"env":"test"}}`, ln.Addr())))
+	defer o.Destroy()
+
+	var span [24]byte
+	for i := range span {
+		span[i] = byte(i + 1)
+	}
+	assert.Nil(t, o.WriteMsg(&LogMsg{
+		Level:      LevelWarning,
+		Msg:        "stock %d low",
+		Args:       []interface{}{3},
+		When:       time.Unix(1600546357, 5),
+		FilePath:   "/src/main.go",
+		LineNumber: 13,
+		Fields:     map[string]interface{}{"sku": "A-1", "count": 3, "ratio": 0.5},
+		Context:    context.WithValue(context.Background(), spanKey{}, span),
+	}))
+	o.Flush()
+
+	assert.Equal(t, []string{"team-a"}, <-logs.tenants)
+	req := <-logs.requests
+	rl := req.ResourceLogs[0]
+	assert.Equal(t, "env", rl.Resource.Attributes[0].Key)
+	assert.Equal(t, "service.name", rl.Resource.Attributes[1].Key)
+	assert.Equal(t, "shop", rl.Resource.Attributes[1].Value.GetStringValue())
+
+	r := rl.InstrumentationLibraryLogs[0].Logs[0]
+	assert.Equal(t, uint64(1600546357000000005), r.TimeUnixNano)
+	assert.Equal(t, otlplogs.SeverityNumber_SEVERITY_NUMBER_WARN, r.SeverityNumber)
+	assert.Equal(t, "warning", r.SeverityText)
+	assert.Equal(t, "stock 3 low", r.Body.GetStringValue())
+	assert.Equal(t, span[:16], r.TraceId)
+	assert.Equal(t, span[16:], r.SpanId)
+	attrs := make(map[string]*common.AnyValue)
+	for _, kv := range r.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "/src/main.go", attrs["code.filepath"].GetStringValue())
+	assert.Equal(t, int64(13), attrs["code.lineno"].GetIntValue())
+	assert.Equal(t, "A-1", attrs["sku"].GetStringValue())
+	assert.Equal(t, int64(3), attrs["count"].GetIntValue())
+	assert.Equal(t, 0.5, attrs["ratio"].GetDoubleValue())
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	requests := make(chan *collogs.ExportLogsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "team-a", r.Header.Get("X-Tenant"))
+		zr, err := gzip.NewReader(r.Body)
+		assert.Nil(t, err)
+		body, err := ioutil.ReadAll(zr)
+		assert.Nil(t, err)
+		req := &collogs.ExportLogsServiceRequest{}
+		assert.Nil(t, proto.Unmarshal(body, req))
+		requests <- req
+	}))
+	defer srv.Close()
+
+	o := NewOTLP()
+	assert.Nil(t, o.Init(fmt.Sprintf(`{"protocol":"http","endpoint":"%s/v1/logs","headers":{"x-tenant":"team-a"},"gzip":true,"level":3}`, srv.URL)))
+	assert.Nil(t, o.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "filtered", When: time.Now()}))
+	assert.Nil(t, o.WriteMsg(&LogMsg{Level: LevelEmergency, Msg: "down", When: time.Now(), Prefix: "db"}))
+	o.Destroy()
+
+	r := (<-requests).ResourceLogs[0].InstrumentationLibraryLogs[0].Logs
+	assert.Equal(t, 1, len(r))
+	assert.Equal(t, otlplogs.SeverityNumber_SEVERITY_NUMBER_FATAL, r[0].SeverityNumber)
+	assert.Equal(t, "down", r[0].Body.GetStringValue())
+	assert.Equal(t, "log.prefix", r[0].Attributes[0].Key)
+	assert.Nil(t, r[0].TraceId)
+
+	assert.NotNil(t, NewOTLP().Init(`{"protocol":"thrift"}`))
+}
@@ -29,7 +29,8 @@ import (
 	"github.com/pkg/errors"
 )
 
-// RCWriter implements LoggerInterface and is used to send Ram Chandra webhook
+// RCWriter implements LoggerInterface and is used to send Ram Chandra webhook.
+// It is a preset of the http adapter, so messages are posted in batches.
 type RCWriter struct {
 	AuthorName  string `json:"authorname"`
 	Title       string `json:"title"`
@@ -40,16 +41,22 @@ type RCWriter struct {
 
 	formatter LogFormatter
 	Formatter string `json:"formatter"`
+	HTTPOptions
+	DigestOptions
+
+	sender   *httpSender
+	digester *digester
 }
 
 // newRCWriter creates Ram Chandra writer.
 func newRCWriter() Logger {
-	res := &RCWriter{Level: LevelTrace}
+	res := &RCWriter{Level: LevelTrace, HTTPOptions: defaultHTTPOptions(), DigestOptions: defaultDigestOptions()}
 	res.formatter = res
 	return res
 }
 
-// Init RCWriter with json config string
+// Init RCWriter with json config string. With digest on, a burst of
+// messages is posted as one summary (see DigestOptions).
 func (s *RCWriter) Init(config string) error {
 
 	res := json.Unmarshal([]byte(config), s)
@@ -60,6 +67,15 @@ func (s *RCWriter) Init(config string) error {
 		}
 		s.formatter = fmtr
 	}
+	if res != nil {
+		return res
+	}
+
+	s.Destroy()
+	s.sender, res = newHTTPSender("RCWriter", http.MethodPost, s.WebhookURL, "application/x-www-form-urlencoded", s.HTTPOptions, s.encode, nil)
+	if res == nil && s.Digest {
+		s.digester = newDigester(s.DigestOptions, s.sender.add)
+	}
 	return res
 }
 
@@ -73,43 +89,56 @@ func (s *RCWriter) SetFormatter(f LogFormatter) {
 	s.formatter = f
 }
 
-// WriteMsg writes message in SMTP writer.
-// Sends an email with subject and only this message.
+// WriteMsg queues the message for the next webhook post, or counts it
+// in the current digest.
 func (s *RCWriter) WriteMsg(lm *LogMsg) error {
 	if lm.Level > s.Level {
 		return nil
 	}
+	if s.digester != nil {
+		s.digester.add(lm, s.formatter.Format(lm))
+		return nil
+	}
+	s.sender.add(lm, s.formatter.Format(lm))
+	return nil
+}
 
-	text := s.formatter.Format(lm)
-
+// encode returns the webhook form for a batch.
+func (s *RCWriter) encode(entries []BatchEntry) ([]byte, error) {
 	form := url.Values{}
 	form.Add("authorName", s.AuthorName)
 	form.Add("title", s.Title)
-	form.Add("text", text)
+	form.Add("text", joinedText(entries))
 	if s.RedirectURL != "" {
 		form.Add("redirectUrl", s.RedirectURL)
 	}
 	if s.ImageURL != "" {
 		form.Add("imageUrl", s.ImageURL)
 	}
-
-	resp, err := http.PostForm(s.WebhookURL, form)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("post webhook failed %s %d", resp.Status, resp.StatusCode)
-	}
-	return nil
+	return []byte(form.Encode()), nil
 }
 
-// Flush implementing method. empty.
+// Flush sends the current digest and blocks until the queued messages
+// are posted.
 func (s *RCWriter) Flush() {
+	if s.digester != nil {
+		s.digester.Flush()
+	}
+	if s.sender != nil {
+		s.sender.Flush()
+	}
 }
 
-// Destroy implementing method. empty.
+// Destroy posts the queued messages and stops the writer.
 func (s *RCWriter) Destroy() {
+	if s.digester != nil {
+		s.digester.close()
+		s.digester = nil
+	}
+	if s.sender != nil {
+		s.sender.close()
+		s.sender = nil
+	}
 }
 
 func init() {
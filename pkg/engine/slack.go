@@ -21,32 +21,102 @@ package engine
 // THE SOFTWARE.
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 )
 
-// SLACKWriter implements LoggerInterface and is used to send Ram Chandra webhook
+// Slack message styles.
+const (
+	SlackText        = "text"
+	SlackBlocks      = "blocks"
+	SlackAttachments = "attachments"
+)
+
+// slackMaxBlocks is the most blocks Slack takes in one message; each log
+// message uses two.
+const slackMaxBlocks = 50
+
+// Slack rejects a message whose section text or context element is
+// longer than these, in characters.
+const (
+	slackMaxSection = 3000
+	slackMaxContext = 2000
+)
+
+// slackColors are the attachment colours by level.
+var slackColors = [LevelDebug + 1]string{
+	"#7a0019", // Emergency
+	"#a30200", // Alert
+	"#d40e0d", // Critical
+	"#e01e5a", // Error
+	"#ecb22e", // Warning
+	"#2eb886", // Notice
+	"#36c5f0", // Informational
+	"#9e9ea6", // Debug
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackBlockText escapes text and cuts it to max characters, ending it
+// with an ellipsis when cut. An escape is not cut in half.
+func slackBlockText(text string, max int) string {
+	text = slackEscaper.Replace(text)
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	n := 0
+	for i := range text {
+		if n == max-1 {
+			text = text[:i]
+			break
+		}
+		n++
+	}
+	if amp := strings.LastIndexByte(text, '&'); amp >= 0 && !strings.Contains(text[amp:], ";") {
+		text = text[:amp]
+	}
+	return text + "…"
+}
+
+// SLACKWriter implements LoggerInterface and is used to send Slack webhook.
+// It is a preset of the http adapter, so messages are posted in batches.
 type SLACKWriter struct {
 	WebhookURL string `json:"webhookurl"`
 	Level      int    `json:"level"`
 	formatter  LogFormatter
 	Formatter  string `json:"formatter"`
+	Style      string `json:"style"`
+	Channel    string `json:"channel"`
+	Username   string `json:"username"`
+	IconEmoji  string `json:"iconemoji"`
+	IconURL    string `json:"iconurl"`
+	HTTPOptions
+	DigestOptions
+
+	sender   *httpSender
+	digester *digester
 }
 
-// newSLACKWriter creates Ram Chandra writer.
+// newSLACKWriter creates Slack writer.
 func newSLACKWriter() Logger {
-	res := &SLACKWriter{Level: LevelTrace}
+	res := &SLACKWriter{Level: LevelTrace, Style: SlackText, HTTPOptions: defaultHTTPOptions(), DigestOptions: defaultDigestOptions()}
 	res.formatter = res
 	return res
 }
 
+// Format returns the text of a message. In the blocks and attachments
+// styles level, caller, prefix and time are shown apart.
 func (s *SLACKWriter) Format(lm *LogMsg) string {
-	// text := fmt.Sprintf("{\"text\": \"%s\"}", msg)
-	return lm.When.Format("2018-03-26 15:04:05") + " " + lm.OldStyleFormat()
+	if s.Style != SlackText {
+		return lm.Message()
+	}
+	return lm.When.Format("2006-01-02 15:04:05") + " " + lm.OldStyleFormat()
 }
 
 func (s *SLACKWriter) SetFormatter(f LogFormatter) {
@@ -54,6 +124,23 @@ func (s *SLACKWriter) SetFormatter(f LogFormatter) {
 }
 
 // Init SLACKWriter with json config string
+// style is "text" (default), "blocks" for Block Kit or "attachments"
+// with a colour per level. channel, username and the icon override the
+// webhook defaults where Slack allows it. Rate limited posts are retried
+// after Retry-After. With digest on, a burst of messages is posted as one
+// summary (see DigestOptions):
+//
+//	{
+//	"webhookurl":"https://hooks.slack.com/services/T000/B000/XXXX",
+//	"style":"attachments",
+//	"channel":"#alerts",
+//	"username":"shop",
+//	"iconemoji":":rotating_light:",
+//	"batchWait":5000,
+//	"digest":true,
+//	"digestWindow":300000,
+//	"level":3
+//	}
 func (s *SLACKWriter) Init(config string) error {
 	res := json.Unmarshal([]byte(config), s)
 
@@ -64,39 +151,137 @@ func (s *SLACKWriter) Init(config string) error {
 		}
 		s.formatter = fmtr
 	}
+	if res != nil {
+		return res
+	}
+	switch s.Style {
+	case SlackText, SlackAttachments:
+	case SlackBlocks:
+		if s.BatchSize > slackMaxBlocks/2 {
+			s.BatchSize = slackMaxBlocks / 2
+		}
+	default:
+		return errors.New(fmt.Sprintf("unknown slack style: %s", s.Style))
+	}
 
+	s.Destroy()
+	s.sender, res = newHTTPSender("SLACKWriter", http.MethodPost, s.WebhookURL, "application/json", s.HTTPOptions, s.encode, nil)
+	if res == nil && s.Digest {
+		s.digester = newDigester(s.DigestOptions, s.sender.add)
+	}
 	return res
 }
 
-// WriteMsg write message in smtp writer.
-// Sends an email with subject and only this message.
+// encode returns the webhook payload for a batch.
+func (s *SLACKWriter) encode(entries []BatchEntry) ([]byte, error) {
+	payload := map[string]interface{}{}
+	if s.Channel != "" {
+		payload["channel"] = s.Channel
+	}
+	if s.Username != "" {
+		payload["username"] = s.Username
+	}
+	if s.IconEmoji != "" {
+		payload["icon_emoji"] = s.IconEmoji
+	}
+	if s.IconURL != "" {
+		payload["icon_url"] = s.IconURL
+	}
+
+	switch s.Style {
+	case SlackBlocks:
+		// text is the notification fallback
+		payload["text"] = slackEscaper.Replace(entries[0].Text)
+		blocks := make([]interface{}, 0, 2*len(entries))
+		for i := range entries {
+			blocks = append(blocks, map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": slackBlockText(entries[i].Text, slackMaxSection)},
+			})
+			var context []interface{}
+			for _, f := range slackFields(&entries[i].Msg) {
+				context = append(context, map[string]string{"type": "mrkdwn", "text": "*" + f[0] + ":* " + slackBlockText(f[1], slackMaxContext-len(f[0])-4)})
+			}
+			blocks = append(blocks, map[string]interface{}{"type": "context", "elements": context})
+		}
+		payload["blocks"] = blocks
+	case SlackAttachments:
+		attachments := make([]interface{}, len(entries))
+		for i := range entries {
+			lm := &entries[i].Msg
+			var fields []interface{}
+			for _, f := range slackFields(lm) {
+				fields = append(fields, map[string]interface{}{"title": f[0], "value": f[1], "short": true})
+			}
+			a := map[string]interface{}{
+				"fallback": entries[i].Text,
+				"text":     slackEscaper.Replace(entries[i].Text),
+				"fields":   fields,
+				"ts":       lm.When.Unix(),
+			}
+			if lm.Level >= 0 && lm.Level <= LevelDebug {
+				a["color"] = slackColors[lm.Level]
+			}
+			attachments[i] = a
+		}
+		payload["attachments"] = attachments
+	default:
+		payload["text"] = joinedText(entries)
+	}
+	return json.Marshal(payload)
+}
+
+// slackFields returns the level, caller and prefix of lm as title and
+// value pairs.
+func slackFields(lm *LogMsg) [][2]string {
+	var fields [][2]string
+	if lm.Level >= 0 && lm.Level <= LevelDebug {
+		fields = append(fields, [2]string{"Level", levelNames[lm.Level]})
+	}
+	if lm.FilePath != "" {
+		fields = append(fields, [2]string{"Caller", fmt.Sprintf("%s:%d", path.Base(lm.FilePath), lm.LineNumber)})
+	}
+	if lm.Prefix != "" {
+		fields = append(fields, [2]string{"Prefix", lm.Prefix})
+	}
+	return fields
+}
+
+// WriteMsg queues the message for the next webhook post, or counts it
+// in the current digest.
 func (s *SLACKWriter) WriteMsg(lm *LogMsg) error {
 	if lm.Level > s.Level {
 		return nil
 	}
-	msg := s.Format(lm)
-	m := make(map[string]string, 1)
-	m["text"] = msg
-
-	body, _ := json.Marshal(m)
-	// resp, err := http.PostForm(s.WebhookURL, form)
-	resp, err := http.Post(s.WebhookURL, "application/json", bytes.NewReader(body))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Post webhook failed %s %d", resp.Status, resp.StatusCode)
+	if s.digester != nil {
+		s.digester.add(lm, s.formatter.Format(lm))
+		return nil
 	}
+	s.sender.add(lm, s.formatter.Format(lm))
 	return nil
 }
 
-// Flush implementing method. empty.
+// Flush sends the current digest and blocks until the queued messages
+// are posted.
 func (s *SLACKWriter) Flush() {
+	if s.digester != nil {
+		s.digester.Flush()
+	}
+	if s.sender != nil {
+		s.sender.Flush()
+	}
 }
 
-// Destroy implementing method. empty.
+// Destroy posts the queued messages and stops the writer.
 func (s *SLACKWriter) Destroy() {
+	if s.digester != nil {
+		s.digester.close()
+		s.digester = nil
+	}
+	if s.sender != nil {
+		s.sender.close()
+		s.sender = nil
+	}
 }
 
 func init() {
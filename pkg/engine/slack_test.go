@@ -20,6 +20,18 @@ package engine
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
 // func TestSLACKWriter_WriteMsg(t *testing.T) {
 // 	sc := `
 // {
@@ -48,3 +60,89 @@ package engine
 // 	}
 //
 // }
+
+type slackTestFormatter struct{}
+
+func (slackTestFormatter) Format(lm *LogMsg) string {
+	return "custom: " + lm.Msg
+}
+
+func slackPayloads(t *testing.T, rec *webhookRecorder) []map[string]interface{} {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	var payloads []map[string]interface{}
+	for _, body := range rec.bodies {
+		var p map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(body), &p))
+		payloads = append(payloads, p)
+	}
+	return payloads
+}
+
+func TestSLACKWriterAttachments(t *testing.T) {
+	rec := &webhookRecorder{fail: 1}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	s := newSLACKWriter()
+	assert.Nil(t, s.Init(fmt.Sprintf(`{"webhookurl":"%s","style":"attachments","channel":"#alerts","username":"shop","iconemoji":":fire:"}`, srv.URL)))
+	s.WriteMsg(&LogMsg{Level: LevelError, Msg: "a < b", When: time.Unix(1600546357, 0), FilePath: "/src/main.go", LineNumber: 13, Prefix: "api"})
+	s.Destroy()
+
+	// the rate limited post was retried
+	assert.Equal(t, []map[string]interface{}{{
+		"channel":    "#alerts",
+		"username":   "shop",
+		"icon_emoji": ":fire:",
+		"attachments": []interface{}{map[string]interface{}{
+			"fallback": "a < b",
+			"text":     "a &lt; b",
+			"color":    "#e01e5a",
+			"ts":       float64(1600546357),
+			"fields": []interface{}{
+				map[string]interface{}{"title": "Level", "value": "error", "short": true},
+				map[string]interface{}{"title": "Caller", "value": "main.go:13", "short": true},
+				map[string]interface{}{"title": "Prefix", "value": "api", "short": true},
+			},
+		}},
+	}}, slackPayloads(t, rec))
+}
+
+func TestSLACKWriterBlocks(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	RegisterFormatter("slack-test", slackTestFormatter{})
+	s := newSLACKWriter()
+	assert.Nil(t, s.Init(fmt.Sprintf(`{"webhookurl":"%s","style":"blocks","formatter":"slack-test","batchSize":100}`, srv.URL)))
+	assert.Equal(t, slackMaxBlocks/2, s.(*SLACKWriter).BatchSize)
+	s.WriteMsg(&LogMsg{Level: LevelWarning, Msg: "disk low", When: time.Now()})
+	s.Destroy()
+
+	assert.Equal(t, []map[string]interface{}{{
+		"text": "custom: disk low",
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]interface{}{"type": "mrkdwn", "text": "custom: disk low"},
+			},
+			map[string]interface{}{
+				"type":     "context",
+				"elements": []interface{}{map[string]interface{}{"type": "mrkdwn", "text": "*Level:* warning"}},
+			},
+		},
+	}}, slackPayloads(t, rec))
+
+	assert.NotNil(t, newSLACKWriter().Init(`{"style":"html"}`))
+}
+
+func TestSlackBlockText(t *testing.T) {
+	assert.Equal(t, "a &lt; b", slackBlockText("a < b", 10))
+	assert.Equal(t, "héllo wo…", slackBlockText("héllo world", 9))
+	// the escape of & is not cut in half
+	assert.Equal(t, "abcd…", slackBlockText("abcd&efgh", 8))
+
+	long := slackBlockText(strings.Repeat("x", 5000), slackMaxSection)
+	assert.Equal(t, slackMaxSection, utf8.RuneCountInString(long))
+}
@@ -21,47 +21,125 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"bytes"
+	"crypto/rand"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"os"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// SMTP TLS modes.
+const (
+	SMTPTLSNone     = "none"     // plain text, for relays on localhost
+	SMTPStartTLS    = "starttls" // upgrade with STARTTLS, usually on port 587
+	SMTPImplicitTLS = "implicit" // TLS from the start, usually on port 465
+)
+
+// SMTP authentication mechanisms.
+const (
+	SMTPAuthPlain   = "plain"
+	SMTPAuthLogin   = "login"
+	SMTPAuthCRAMMD5 = "cram-md5"
+)
+
+// smtpSubjectMax is the longest subject in runes, so that headers stay
+// within the line length limit.
+const smtpSubjectMax = 200
+
+// SMTPSubject is the data of the subject templates.
+type SMTPSubject struct {
+	Level    string // level name, like "error"
+	Message  string // first line of the message
+	Prefix   string
+	Hostname string
+}
+
 // SMTPWriter implements LoggerInterface and is used to send emails via given SMTP-server.
 type SMTPWriter struct {
-	Username           string   `json:"username"`
-	Password           string   `json:"password"`
-	Host               string   `json:"host"`
-	Subject            string   `json:"subject"`
-	FromAddress        string   `json:"fromAddress"`
-	RecipientAddresses []string `json:"sendTos"`
-	Level              int      `json:"level"`
+	Username           string            `json:"username"`
+	Password           string            `json:"password"`
+	Host               string            `json:"host"`
+	Subject            string            `json:"subject"`
+	Subjects           map[string]string `json:"subjects"` // subject templates by level name
+	FromAddress        string            `json:"fromAddress"`
+	FromName           string            `json:"fromName"`
+	RecipientAddresses []string          `json:"sendTos"`
+	Level              int               `json:"level"`
+	TLS                string            `json:"tls"`
+	Auth               string            `json:"auth"`
+	HTML               bool              `json:"html"`
+	Timeout            int               `json:"timeout"`
 	formatter          LogFormatter
 	Formatter          string `json:"formatter"`
+	TLSOptions
+	DigestOptions
+
+	subjects map[int]*template.Template
+	hostname string
+	from     mail.Address   // fromAddress and fromName, parsed
+	to       []mail.Address // sendTos, parsed
+	digester *digester
 }
 
 // NewSMTPWriter creates the smtp writer.
 func newSMTPWriter() Logger {
-	res := &SMTPWriter{Level: LevelTrace}
+	res := &SMTPWriter{
+		Level:         LevelTrace,
+		Subject:       "[{{.Level}}] {{.Message}}",
+		HTML:          true,
+		Timeout:       10000,
+		DigestOptions: defaultDigestOptions(),
+	}
 	res.formatter = res
 	return res
 }
 
 // Init SMTP writer with json config.
 // config like:
+//
 //	{
 //		"username":"example@bhojpur.net",
-//		"password:"password",
+//		"password":"password",
 //		"host":"smtp.bhojpur.net:465",
-//		"subject":"email title",
+//		"tls":"implicit",
+//		"auth":"login",
+//		"subject":"[{{.Level}}] {{.Hostname}}: {{.Message}}",
+//		"subjects":{"critical":"CRITICAL on {{.Hostname}}"},
 //		"fromAddress":"from@bhojpur.net",
+//		"fromName":"Shop alerts",
 //		"sendTos":["email1","email2"],
-//		"level":LevelError
+//		"level":LevelError,
+//		"digest":true,
+//		"digestWindow":300000,
+//		"digestSize":100,
+//		"digestFirst":true
 //	}
+//
+// tls is "none", "starttls" or "implicit"; by default implicit TLS is used
+// on port 465 and STARTTLS elsewhere. Server certificates are verified
+// unless insecureSkipVerify is set, ca, cert and key work as for the
+// other network adapters. auth is "plain" (default), "login" or
+// "cram-md5". Subjects are templates of SMTPSubject, picked by level name
+// and falling back to subject. Mails carry a text and an HTML part unless
+// html is false.
+//
+// With digest on, messages are mailed as one summary per window instead
+// of one mail each (see DigestOptions).
 func (s *SMTPWriter) Init(config string) error {
 	res := json.Unmarshal([]byte(config), s)
 	if res == nil && len(s.Formatter) > 0 {
@@ -71,13 +149,107 @@ func (s *SMTPWriter) Init(config string) error {
 		}
 		s.formatter = fmtr
 	}
-	return res
+	if res != nil {
+		return res
+	}
+
+	if s.TLS == "" {
+		s.TLS = SMTPStartTLS
+		if _, port, _ := net.SplitHostPort(s.Host); port == "465" {
+			s.TLS = SMTPImplicitTLS
+		}
+	}
+	switch s.TLS {
+	case SMTPTLSNone, SMTPStartTLS, SMTPImplicitTLS:
+	default:
+		return errors.New(fmt.Sprintf("unknown smtp tls mode: %s", s.TLS))
+	}
+	switch s.Auth {
+	case "", SMTPAuthPlain, SMTPAuthLogin, SMTPAuthCRAMMD5:
+	default:
+		return errors.New(fmt.Sprintf("unknown smtp auth: %s", s.Auth))
+	}
+	// without fromAddress, mails come from the username when it is an
+	// address, as most servers only let users send as themselves
+	fromAddress := s.FromAddress
+	if fromAddress == "" {
+		fromAddress = s.Username
+	}
+	from, err := mail.ParseAddress(fromAddress)
+	if err != nil {
+		if s.FromAddress == "" {
+			return errors.New("smtp fromAddress is required unless username is an address")
+		}
+		return errors.Wrap(err, "smtp fromAddress")
+	}
+	s.from = *from
+	if s.FromName != "" {
+		s.from.Name = s.FromName
+	}
+	s.to = make([]mail.Address, len(s.RecipientAddresses))
+	for i, to := range s.RecipientAddresses {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return errors.Wrap(err, "smtp sendTos")
+		}
+		s.to[i] = *addr
+	}
+	if err := s.parseSubjects(); err != nil {
+		return err
+	}
+	s.hostname, _ = os.Hostname()
+
+	s.Destroy()
+	if s.Digest {
+		s.digester = newDigester(s.DigestOptions, func(lm *LogMsg, text string) {
+			if err := s.send(lm, text); err != nil {
+				fmt.Fprintf(os.Stderr, "SMTPWriter: %s\n", err)
+			}
+		})
+	}
+	return nil
+}
+
+// parseSubjects compiles subject for every level, then the templates of
+// subjects over it.
+func (s *SMTPWriter) parseSubjects() error {
+	def, err := template.New("subject").Parse(s.Subject)
+	if err != nil {
+		return errors.Wrap(err, "smtp subject")
+	}
+	s.subjects = map[int]*template.Template{}
+	for name, text := range s.Subjects {
+		level := -1
+		for i, n := range levelNames {
+			if n == name {
+				level = i
+			}
+		}
+		if level < 0 {
+			return errors.New(fmt.Sprintf("smtp subjects: unknown level: %s", name))
+		}
+		if s.subjects[level], err = template.New(name).Parse(text); err != nil {
+			return errors.Wrap(err, "smtp subjects")
+		}
+	}
+	for i := range levelNames {
+		if s.subjects[i] == nil {
+			s.subjects[i] = def
+		}
+	}
+	return nil
 }
 
 func (s *SMTPWriter) getSMTPAuth(host string) smtp.Auth {
 	if len(strings.Trim(s.Username, " ")) == 0 && len(strings.Trim(s.Password, " ")) == 0 {
 		return nil
 	}
+	switch s.Auth {
+	case SMTPAuthLogin:
+		return &loginAuth{username: s.Username, password: s.Password, host: host}
+	case SMTPAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.Username, s.Password)
+	}
 	return smtp.PlainAuth(
 		"",
 		s.Username,
@@ -90,20 +262,58 @@ func (s *SMTPWriter) SetFormatter(f LogFormatter) {
 	s.formatter = f
 }
 
-func (s *SMTPWriter) sendMail(hostAddressWithPort string, auth smtp.Auth, fromAddress string, recipients []string, msgContent []byte) error {
-	client, err := smtp.Dial(hostAddressWithPort)
+// dial connects to the server, with TLS from the start in implicit mode
+// or after STARTTLS.
+func (s *SMTPWriter) dial(host string) (*smtp.Client, error) {
+	timeout := time.Duration(s.Timeout) * time.Millisecond
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	var err error
+	if s.TLS == SMTPImplicitTLS {
+		var cfg *tls.Config
+		if cfg, err = s.ClientConfig(s.Host); err != nil {
+			return nil, err
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.Host, cfg)
+	} else {
+		conn, err = dialer.Dial("tcp", s.Host)
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
 	}
 
-	host, _, _ := net.SplitHostPort(hostAddressWithPort)
-	tlsConn := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         host,
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.TLS == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("smtp: server does not offer STARTTLS, set tls to none to send in plain text")
+		}
+		cfg, err := s.ClientConfig(s.Host)
+		if err == nil {
+			err = client.StartTLS(cfg)
+		}
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
 	}
-	if err = client.StartTLS(tlsConn); err != nil {
+	return client, nil
+}
+
+func (s *SMTPWriter) sendMail(host string, auth smtp.Auth, fromAddress string, recipients []string, msgContent []byte) error {
+	client, err := s.dial(host)
+	if err != nil {
 		return err
 	}
+	defer client.Close()
 
 	if auth != nil {
 		if err = client.Auth(auth); err != nil {
@@ -139,38 +349,175 @@ func (s *SMTPWriter) sendMail(hostAddressWithPort string, auth smtp.Auth, fromAd
 }
 
 func (s *SMTPWriter) Format(lm *LogMsg) string {
-	return lm.OldStyleFormat()
+	return lm.When.Format("2006-01-02 15:04:05") + " " + lm.OldStyleFormat()
 }
 
 // WriteMsg writes message in smtp writer.
-// Sends an email with subject and only this message.
+// Sends an email with subject and only this message, or counts it in the
+// current digest.
 func (s *SMTPWriter) WriteMsg(lm *LogMsg) error {
 	if lm.Level > s.Level {
 		return nil
 	}
+	if s.digester != nil {
+		s.digester.add(lm, s.formatter.Format(lm))
+		return nil
+	}
+	return s.send(lm, s.formatter.Format(lm))
+}
+
+// send mails msg, the formatted text of lm.
+func (s *SMTPWriter) send(lm *LogMsg, msg string) error {
+	content, err := s.message(lm, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(s.Host)
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, len(s.to))
+	for i := range s.to {
+		recipients[i] = s.to[i].Address
+	}
+	return s.sendMail(host, s.getSMTPAuth(host), s.from.Address, recipients, content)
+}
+
+// message returns the RFC 5322 message for msg, a multipart/alternative
+// of text and HTML unless html is off.
+func (s *SMTPWriter) message(lm *LogMsg, msg string, now time.Time) ([]byte, error) {
+	subject, err := s.subject(lm, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	to := make([]string, len(s.to))
+	for i := range s.to {
+		to[i] = s.to[i].String()
+	}
+	domain := s.hostname
+	if at := strings.LastIndexByte(s.from.Address, '@'); at >= 0 {
+		domain = s.from.Address[at+1:]
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	var b bytes.Buffer
+	h := func(key, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	h("From", s.from.String())
+	h("To", strings.Join(to, ", "))
+	h("Subject", mime.QEncoding.Encode("utf-8", subject))
+	h("Date", now.Format(time.RFC1123Z))
+	h("Message-ID", fmt.Sprintf("<%d.%x@%s>", now.UnixNano(), id, domain))
+	h("MIME-Version", "1.0")
+
+	text := strings.ReplaceAll(msg, "\n", "\r\n")
+	if !s.HTML {
+		h("Content-Type", "text/plain; charset=UTF-8")
+		h("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		return b.Bytes(), writeQuotedPrintable(&b, text)
+	}
+
+	mw := multipart.NewWriter(&b)
+	h("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	b.WriteString("\r\n")
+	parts := []struct{ typ, body string }{
+		{"text/plain", text},
+		{"text/html", "<html><body><pre style=\"font-family:monospace\">" + html.EscapeString(text) + "</pre></body></html>"},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.typ + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeQuotedPrintable(w, p.body); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), mw.Close()
+}
 
-	hp := strings.Split(s.Host, ":")
+// subject returns the subject of the mail for lm.
+func (s *SMTPWriter) subject(lm *LogMsg, msg string) (string, error) {
+	tmpl := s.subjects[LevelDebug]
+	if lm.Level >= 0 && lm.Level <= LevelDebug {
+		tmpl = s.subjects[lm.Level]
+	}
+	data := SMTPSubject{Prefix: lm.Prefix, Hostname: s.hostname}
+	if lm.Level >= 0 && lm.Level <= LevelDebug {
+		data.Level = levelNames[lm.Level]
+	}
+	data.Message = msg
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		data.Message = msg[:i]
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	subject := strings.Join(strings.Fields(b.String()), " ")
+	if r := []rune(subject); len(r) > smtpSubjectMax {
+		subject = string(r[:smtpSubjectMax])
+	}
+	return subject, nil
+}
 
-	// Set up authentication information.
-	auth := s.getSMTPAuth(hp[0])
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
 
-	msg := s.Format(lm)
+// loginAuth implements the LOGIN mechanism, which some servers offer
+// instead of PLAIN.
+type loginAuth struct {
+	username, password, host string
+}
 
-	// Connect to the server, authenticate, set the sender and recipient,
-	// and send the email all in one step.
-	contentType := "Content-Type: text/plain" + "; charset=UTF-8"
-	mailmsg := []byte("To: " + strings.Join(s.RecipientAddresses, ";") + "\r\nFrom: " + s.FromAddress + "<" + s.FromAddress +
-		">\r\nSubject: " + s.Subject + "\r\n" + contentType + "\r\n\r\n" + fmt.Sprintf(".%s", lm.When.Format("2018-03-26 15:04:05")) + msg)
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && a.host != "localhost" && a.host != "127.0.0.1" && a.host != "::1" {
+		return "", nil, errors.New("smtp: LOGIN auth needs an encrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("smtp: wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
 
-	return s.sendMail(s.Host, auth, s.FromAddress, s.RecipientAddresses, mailmsg)
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, errors.New(fmt.Sprintf("smtp: unexpected LOGIN challenge: %s", fromServer))
 }
 
-// Flush implementing method. empty.
+// Flush mails the current digest.
 func (s *SMTPWriter) Flush() {
+	if s.digester != nil {
+		s.digester.Flush()
+	}
 }
 
-// Destroy implementing method. empty.
+// Destroy mails the current digest and stops collecting.
 func (s *SMTPWriter) Destroy() {
+	if s.digester != nil {
+		s.digester.close()
+		s.digester = nil
+	}
 }
 
 func init() {
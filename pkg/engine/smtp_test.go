@@ -21,8 +21,24 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSmtp(t *testing.T) {
@@ -31,3 +47,266 @@ func TestSmtp(t *testing.T) {
 	log.Critical("sendmail critical")
 	time.Sleep(time.Second * 30)
 }
+
+// smtpStandIn is a local SMTP server recording what it is sent. It
+// offers STARTTLS when starttls is set and takes every password but
+// checks CRAM-MD5 responses against "secret".
+type smtpStandIn struct {
+	ln       net.Listener
+	starttls *tls.Config
+
+	lock  sync.Mutex
+	mails []smtpStandInMail
+}
+
+type smtpStandInMail struct {
+	auth string
+	tls  bool
+	from string
+	to   []string
+	data string
+}
+
+// newSMTPStandIn listens on localhost, with TLS from the start when
+// implicit is set.
+func newSMTPStandIn(t *testing.T, implicit, starttls *tls.Config) *smtpStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	if implicit != nil {
+		ln = tls.NewListener(ln, implicit)
+	}
+	s := &smtpStandIn{ln: ln, starttls: starttls}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, implicit != nil)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn, secure bool) {
+	defer func() { conn.Close() }()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	readB64 := func() string {
+		line, _ := tp.ReadLine()
+		b, _ := base64.StdEncoding.DecodeString(line)
+		return string(b)
+	}
+	m := smtpStandInMail{tls: secure}
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			tp.PrintfLine("500 empty command")
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "EHLO", "HELO":
+			ext := []string{"localhost", "AUTH PLAIN LOGIN CRAM-MD5"}
+			if s.starttls != nil && !m.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, e)
+			}
+		case "STARTTLS":
+			if s.starttls == nil {
+				tp.PrintfLine("502 not offered")
+				continue
+			}
+			tp.PrintfLine("220 ready")
+			tc := tls.Server(conn, s.starttls)
+			if tc.Handshake() != nil {
+				return
+			}
+			conn, tp, m.tls = tc, textproto.NewConn(tc), true
+		case "AUTH":
+			switch strings.ToUpper(fields[1]) {
+			case "PLAIN":
+				b, _ := base64.StdEncoding.DecodeString(fields[2])
+				parts := strings.Split(string(b), "\x00")
+				m.auth = "PLAIN " + parts[1] + ":" + parts[2]
+			case "LOGIN":
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				user := readB64()
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				m.auth = "LOGIN " + user + ":" + readB64()
+			case "CRAM-MD5":
+				challenge := "<1.1@localhost>"
+				tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+				resp := strings.Fields(readB64())
+				d := hmac.New(md5.New, []byte("secret"))
+				d.Write([]byte(challenge))
+				if len(resp) != 2 || resp[1] != hex.EncodeToString(d.Sum(nil)) {
+					tp.PrintfLine("535 bad digest")
+					continue
+				}
+				m.auth = "CRAM-MD5 " + resp[0]
+			}
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			m.from = line[strings.IndexByte(line, '<')+1 : strings.IndexByte(line, '>')]
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			m.to = append(m.to, line[strings.IndexByte(line, '<')+1:strings.IndexByte(line, '>')])
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, _ := tp.ReadDotBytes()
+			m.data = string(data)
+			s.lock.Lock()
+			s.mails = append(s.mails, m)
+			s.lock.Unlock()
+			m = smtpStandInMail{auth: m.auth, tls: m.tls}
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpStandIn) received() []smtpStandInMail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]smtpStandInMail(nil), s.mails...)
+}
+
+func TestSMTPWriterStartTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := newSMTPStandIn(t, nil, &tls.Config{Certificates: []tls.Certificate{pki.issue(t, "server", true)}})
+
+	w := newSMTPWriter()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"host":"%s","ca":"%s","auth":"login","username":"shop","password":"pw",
+		"fromAddress":"alerts@bhojpur.net","fromName":"Shop alerts","sendTos":["a@bhojpur.net","b@bhojpur.net"],
+		"subjects":{"critical":"CRIT {{.Prefix}}: {{.Message}}"}}`, srv.ln.Addr(), pki.path("ca.pem"))))
+	when := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, w.WriteMsg(&LogMsg{Level: LevelCritical, Msg: "disk <%s> full", Args: []interface{}{"/var"}, When: when, Prefix: "api"}))
+	assert.Nil(t, w.WriteMsg(&LogMsg{Level: LevelError, Msg: "résumé failed", When: when}))
+
+	mails := srv.received()
+	assert.Len(t, mails, 2)
+	assert.Equal(t, "LOGIN shop:pw", mails[0].auth)
+	assert.True(t, mails[0].tls)
+	assert.Equal(t, "alerts@bhojpur.net", mails[0].from)
+	assert.Equal(t, []string{"a@bhojpur.net", "b@bhojpur.net"}, mails[0].to)
+
+	msg, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+	assert.Nil(t, err)
+	assert.Equal(t, `"Shop alerts" <alerts@bhojpur.net>`, msg.Header.Get("From"))
+	assert.Equal(t, "<a@bhojpur.net>, <b@bhojpur.net>", msg.Header.Get("To"))
+	assert.Equal(t, "CRIT api: 2021-03-04 10:00:00 [C] api disk </var> full", msg.Header.Get("Subject"))
+	_, err = msg.Header.Date()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(msg.Header.Get("Message-ID"), "@bhojpur.net>"))
+
+	typ, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", typ)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		b, _ := ioutil.ReadAll(p)
+		bodies = append(bodies, p.Header.Get("Content-Type")+"|"+string(b))
+	}
+	assert.Equal(t, []string{
+		"text/plain; charset=UTF-8|2021-03-04 10:00:00 [C] api disk </var> full",
+		"text/html; charset=UTF-8|<html><body><pre style=\"font-family:monospace\">2021-03-04 10:00:00 [C] api disk &lt;/var&gt; full</pre></body></html>",
+	}, bodies)
+
+	msg, err = mail.ReadMessage(strings.NewReader(mails[1].data))
+	assert.Nil(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[error] 2021-03-04 10:00:00 [E] résumé failed", subject)
+}
+
+func TestSMTPWriterImplicitTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := newSMTPStandIn(t, &tls.Config{Certificates: []tls.Certificate{pki.issue(t, "server", true)}}, nil)
+
+	w := newSMTPWriter()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"host":"%s","tls":"implicit","ca":"%s","auth":"cram-md5","username":"shop","password":"secret",
+		"fromAddress":"alerts@bhojpur.net","sendTos":["a@bhojpur.net"],"html":false}`, srv.ln.Addr(), pki.path("ca.pem"))))
+	assert.Nil(t, w.WriteMsg(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}))
+
+	mails := srv.received()
+	assert.Len(t, mails, 1)
+	assert.Equal(t, "CRAM-MD5 shop", mails[0].auth)
+	msg, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+	assert.Nil(t, err)
+	assert.Equal(t, "text/plain; charset=UTF-8", msg.Header.Get("Content-Type"))
+
+	// the server certificate is verified
+	w = newSMTPWriter()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"host":"%s","tls":"implicit","fromAddress":"alerts@bhojpur.net","sendTos":["a@bhojpur.net"]}`, srv.ln.Addr())))
+	assert.NotNil(t, w.WriteMsg(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}))
+	assert.Len(t, srv.received(), 1)
+}
+
+func TestSMTPWriterPlain(t *testing.T) {
+	srv := newSMTPStandIn(t, nil, nil)
+
+	w := newSMTPWriter()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"host":"%s","tls":"none","username":"shop","password":"pw",
+		"fromAddress":"alerts@bhojpur.net","sendTos":["a@bhojpur.net"]}`, srv.ln.Addr())))
+	assert.Nil(t, w.WriteMsg(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}))
+	mails := srv.received()
+	assert.Len(t, mails, 1)
+	assert.Equal(t, "PLAIN shop:pw", mails[0].auth)
+	assert.False(t, mails[0].tls)
+
+	// STARTTLS is required unless tls is none
+	w = newSMTPWriter()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"host":"%s","fromAddress":"alerts@bhojpur.net","sendTos":["a@bhojpur.net"]}`, srv.ln.Addr())))
+	assert.NotNil(t, w.WriteMsg(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}))
+
+	// named recipients get their address alone in RCPT
+	w = newSMTPWriter()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"host":"%s","tls":"none","fromAddress":"Alerts <alerts@bhojpur.net>","sendTos":["Ops <ops@bhojpur.net>"]}`, srv.ln.Addr())))
+	assert.Nil(t, w.WriteMsg(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}))
+	mails = srv.received()
+	assert.Len(t, mails, 2)
+	assert.Equal(t, "alerts@bhojpur.net", mails[1].from)
+	assert.Equal(t, []string{"ops@bhojpur.net"}, mails[1].to)
+	msg, err := mail.ReadMessage(strings.NewReader(mails[1].data))
+	assert.Nil(t, err)
+	assert.Equal(t, `"Alerts" <alerts@bhojpur.net>`, msg.Header.Get("From"))
+	assert.Equal(t, `"Ops" <ops@bhojpur.net>`, msg.Header.Get("To"))
+
+	// without fromAddress, mails come from the username
+	w = newSMTPWriter()
+	assert.Nil(t, w.Init(fmt.Sprintf(`{"host":"%s","tls":"none","username":"shop@bhojpur.net","password":"pw","sendTos":["a@bhojpur.net"]}`, srv.ln.Addr())))
+	assert.Nil(t, w.WriteMsg(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()}))
+	mails = srv.received()
+	assert.Len(t, mails, 3)
+	assert.Equal(t, "shop@bhojpur.net", mails[2].from)
+	msg, err = mail.ReadMessage(strings.NewReader(mails[2].data))
+	assert.Nil(t, err)
+	assert.Equal(t, "<shop@bhojpur.net>", msg.Header.Get("From"))
+	assert.NotNil(t, newSMTPWriter().Init(`{"host":"localhost:25","sendTos":["a@bhojpur.net"]}`))
+	assert.NotNil(t, newSMTPWriter().Init(`{"host":"localhost:25","username":"shop","sendTos":["a@bhojpur.net"]}`))
+	assert.NotNil(t, newSMTPWriter().Init(`{"tls":"ssl","fromAddress":"a@bhojpur.net"}`))
+	assert.NotNil(t, newSMTPWriter().Init(`{"auth":"ntlm","fromAddress":"a@bhojpur.net"}`))
+	assert.NotNil(t, newSMTPWriter().Init(`{"fromAddress":"a@bhojpur.net","subjects":{"fatal":"x"}}`))
+	assert.NotNil(t, newSMTPWriter().Init(`{"fromAddress":"a@bhojpur.net","sendTos":["not an address"]}`))
+}
@@ -0,0 +1,281 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	splunkEventPath = "/services/collector/event"
+	splunkAckPath   = "/services/collector/ack"
+)
+
+// splunkWriter implements LoggerInterface.
+// It sends batches of events to the Splunk HTTP Event Collector.
+type splunkWriter struct {
+	formatter LogFormatter
+	sender    *httpSender
+	ackURL    string
+	stop      chan struct{} // closed by Destroy to end the ack polling
+
+	Formatter   string `json:"formatter"`
+	Level       int    `json:"level"`
+	URL         string `json:"url"`
+	Token       string `json:"token"`
+	Index       string `json:"index"`
+	Source      string `json:"source"`
+	SourceType  string `json:"sourcetype"`
+	Host        string `json:"host"`
+	Ack         bool   `json:"ack"`
+	Channel     string `json:"channel"`
+	AckTimeout  int    `json:"ackTimeout"`
+	AckInterval int    `json:"ackInterval"`
+	HTTPOptions
+}
+
+// NewSplunk creates a Splunk HEC writer returning as LoggerInterface.
+func NewSplunk() Logger {
+	s := &splunkWriter{
+		Level:       LevelDebug,
+		AckTimeout:  30000,
+		AckInterval: 1000,
+		HTTPOptions: defaultHTTPOptions(),
+	}
+	s.formatter = s
+	return s
+}
+
+// Init initializes the Splunk writer with json config.
+// url is the HEC base url; the event path is added when missing. With
+// ack set, a batch counts as sent once the indexers acknowledge it, and
+// is sent again otherwise. Durations are in milliseconds:
+//
+//	{
+//	"url":"https://splunk.bhojpur.net:8088",
+//	"token":"00000000-0000-0000-0000-000000000000",
+//	"index":"main",
+//	"source":"shop",
+//	"sourcetype":"_json",
+//	"ack":true,
+//	"ackTimeout":30000,
+//	"batchSize":100,
+//	"level":6
+//	}
+func (s *splunkWriter) Init(config string) error {
+	if err := json.Unmarshal([]byte(config), s); err != nil {
+		return err
+	}
+	if len(s.Formatter) > 0 {
+		fmtr, ok := GetFormatter(s.Formatter)
+		if !ok {
+			return errors.New(fmt.Sprintf("the formatter with name: %s not found", s.Formatter))
+		}
+		s.formatter = fmtr
+	}
+	if s.URL == "" || s.Token == "" {
+		return errors.New("splunk url and token are required")
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(s.URL, "/"), splunkEventPath)
+	s.URL = base + splunkEventPath
+	s.ackURL = base + splunkAckPath
+	if s.Host == "" {
+		s.Host, _ = os.Hostname()
+	}
+	if s.Ack && s.Channel == "" {
+		s.Channel = newSplunkChannel()
+	}
+	opts := s.HTTPOptions
+	opts.Headers = make(map[string]string, len(s.Headers)+2)
+	for k, v := range s.Headers {
+		opts.Headers[k] = v
+	}
+	opts.Headers["Authorization"] = "Splunk " + s.Token
+	if s.Channel != "" {
+		opts.Headers["X-Splunk-Request-Channel"] = s.Channel
+	}
+	s.Destroy()
+	s.stop = make(chan struct{})
+	var err error
+	s.sender, err = newHTTPSender("splunkWriter", http.MethodPost, s.URL, "application/json", opts, s.encode, s.sent)
+	return err
+}
+
+// newSplunkChannel returns a random channel id in the GUID form HEC expects.
+func newSplunkChannel() string {
+	var b [16]byte
+	rand.Read(b[:])
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (s *splunkWriter) SetFormatter(f LogFormatter) {
+	s.formatter = f
+}
+
+// Format returns the event message.
+func (s *splunkWriter) Format(lm *LogMsg) string {
+	return lm.Message()
+}
+
+// WriteMsg queues the message for the next batch.
+func (s *splunkWriter) WriteMsg(lm *LogMsg) error {
+	if lm.Level > s.Level {
+		return nil
+	}
+	s.sender.add(lm, s.formatter.Format(lm))
+	return nil
+}
+
+// splunkEvent is one event of a HEC request.
+type splunkEvent struct {
+	Time       float64                `json:"time"`
+	Host       string                 `json:"host,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	SourceType string                 `json:"sourcetype,omitempty"`
+	Index      string                 `json:"index,omitempty"`
+	Event      map[string]interface{} `json:"event"`
+	Fields     map[string]string      `json:"fields,omitempty"`
+}
+
+func (s *splunkWriter) event(e *BatchEntry) *splunkEvent {
+	lm := &e.Msg
+	ev := &splunkEvent{
+		Time:       float64(lm.When.UnixNano()/1e6) / 1e3,
+		Host:       s.Host,
+		Source:     s.Source,
+		SourceType: s.SourceType,
+		Index:      s.Index,
+		Event:      map[string]interface{}{"message": e.Text},
+	}
+	if lm.Level >= 0 && lm.Level <= LevelDebug {
+		ev.Event["level"] = levelNames[lm.Level]
+	}
+	if lm.Prefix != "" {
+		ev.Event["prefix"] = lm.Prefix
+	}
+	if lm.FilePath != "" {
+		ev.Event["file"] = lm.FilePath
+		ev.Event["line"] = lm.LineNumber
+	}
+	if len(lm.Fields) > 0 {
+		// indexed fields only take strings
+		ev.Fields = make(map[string]string, len(lm.Fields))
+		for k, v := range lm.Fields {
+			ev.Fields[k] = fmt.Sprint(v)
+		}
+	}
+	return ev
+}
+
+// encode returns one batch as newline delimited events.
+func (s *splunkWriter) encode(entries []BatchEntry) ([]byte, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for i := range entries {
+		if err := enc.Encode(s.event(&entries[i])); err != nil {
+			return nil, err
+		}
+	}
+	return body.Bytes(), nil
+}
+
+// sent waits for the acknowledgement of a posted batch, when enabled.
+func (s *splunkWriter) sent(entries []BatchEntry, body []byte) error {
+	if !s.Ack {
+		return nil
+	}
+	var res struct {
+		AckID *int64 `json:"ackId"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return err
+	}
+	if res.AckID == nil {
+		return errors.New("splunk did not return an ackId, is indexer acknowledgement enabled for the token?")
+	}
+	return s.waitAck(*res.AckID)
+}
+
+// waitAck polls HEC until ackID is acknowledged or ackTimeout expires.
+// Once Destroy is called, it polls one last time and gives the batch up.
+func (s *splunkWriter) waitAck(ackID int64) error {
+	deadline := time.Now().Add(time.Duration(s.AckTimeout) * time.Millisecond)
+	stopped := false
+	for {
+		body, _ := json.Marshal(map[string][]int64{"acks": {ackID}})
+		var res struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		data, err := s.sender.post(s.ackURL, body)
+		if err == nil {
+			err = json.Unmarshal(data, &res)
+		}
+		if err == nil && res.Acks[fmt.Sprint(ackID)] {
+			return nil
+		}
+		if stopped {
+			return errors.New(fmt.Sprintf("splunk ack %d not received before the writer stopped", ackID))
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = fmt.Errorf("splunk ack %d timed out", ackID)
+			}
+			// the batch may not have been indexed, send it again
+			return &retryError{err: err}
+		}
+		select {
+		case <-s.stop:
+			stopped = true
+		case <-time.After(time.Duration(s.AckInterval) * time.Millisecond):
+		}
+	}
+}
+
+// Flush blocks until the queued events are sent.
+func (s *splunkWriter) Flush() {
+	if s.sender != nil {
+		s.sender.Flush()
+	}
+}
+
+// Destroy sends the queued events and stops the writer.
+func (s *splunkWriter) Destroy() {
+	if s.sender != nil {
+		close(s.stop)
+		s.sender.close()
+		s.sender = nil
+	}
+}
+
+func init() {
+	Register(AdapterSplunk, NewSplunk)
+}
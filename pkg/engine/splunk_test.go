@@ -0,0 +1,137 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplunkHEC(t *testing.T) {
+	var lock sync.Mutex
+	var events []splunkEvent
+	posts, polls := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, "Splunk secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "chan-1", r.Header.Get("X-Splunk-Request-Channel"))
+		switch r.URL.Path {
+		case splunkEventPath:
+			posts++
+			if posts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			sc := bufio.NewScanner(r.Body)
+			for sc.Scan() {
+				var ev splunkEvent
+				assert.Nil(t, json.Unmarshal(sc.Bytes(), &ev))
+				events = append(events, ev)
+			}
+			fmt.Fprint(w, `{"text":"Success","code":0,"ackId":7}`)
+		case splunkAckPath:
+			polls++
+			var req map[string][]int64
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, []int64{7}, req["acks"])
+			fmt.Fprintf(w, `{"acks":{"7":%t}}`, polls > 1)
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewSplunk()
+	assert.Nil(t, s.Init(fmt.Sprintf(`{"url":"%s","token":"secret","index":"main","source":"shop","sourcetype":"_json","host":"web-1","ack":true,"channel":"chan-1","ackInterval":10,"retryMin":10,"retryMax":20}`, srv.URL)))
+	defer s.Destroy()
+	s.WriteMsg(&LogMsg{Level: LevelError, Msg: "order %d failed", Args: []interface{}{7}, When: time.Unix(1600546357, 250e6), Prefix: "api", Fields: map[string]interface{}{"user": "bob", "amount": 12}})
+	s.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "second", When: time.Unix(1600546358, 0)})
+	s.Flush()
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 2, posts)
+	assert.Equal(t, 2, polls)
+	assert.Equal(t, []splunkEvent{
+		{
+			Time: 1600546357.25, Host: "web-1", Source: "shop", SourceType: "_json", Index: "main",
+			Event:  map[string]interface{}{"message": "order 7 failed", "level": "error", "prefix": "api"},
+			Fields: map[string]string{"user": "bob", "amount": "12"},
+		},
+		{
+			Time: 1600546358, Host: "web-1", Source: "shop", SourceType: "_json", Index: "main",
+			Event: map[string]interface{}{"message": "second", "level": "info"},
+		},
+	}, events)
+}
+
+func TestSplunkAckDestroy(t *testing.T) {
+	var lock sync.Mutex
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path == splunkAckPath {
+			polls++
+			fmt.Fprint(w, `{"acks":{"7":false}}`)
+			return
+		}
+		fmt.Fprint(w, `{"text":"Success","code":0,"ackId":7}`)
+	}))
+	defer srv.Close()
+
+	s := NewSplunk()
+	assert.Nil(t, s.Init(fmt.Sprintf(`{"url":"%s","token":"secret","ack":true,"ackTimeout":60000,"ackInterval":60000,"batchWait":10}`, srv.URL)))
+	s.WriteMsg(&LogMsg{Level: LevelError, Msg: "down", When: time.Now()})
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return polls == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// Destroy ends the wait for the ack with one last poll
+	start := time.Now()
+	s.Destroy()
+	assert.Less(t, time.Since(start), 10*time.Second)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 2, polls)
+}
+
+func TestSplunkInit(t *testing.T) {
+	assert.NotNil(t, NewSplunk().Init(`{"url":"http://localhost:8088"}`))
+
+	s := NewSplunk().(*splunkWriter)
+	assert.Nil(t, s.Init(`{"url":"http://localhost:8088/services/collector/event","token":"t","ack":true}`))
+	defer s.Destroy()
+	assert.Equal(t, "http://localhost:8088/services/collector/event", s.URL)
+	assert.Equal(t, "http://localhost:8088/services/collector/ack", s.ackURL)
+	assert.Equal(t, 36, len(s.Channel))
+}
@@ -0,0 +1,340 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Syslog message formats.
+const (
+	SyslogRFC5424 = "rfc5424"
+	SyslogRFC3164 = "rfc3164"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3,
+	"auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogLocalAddrs are the local syslog sockets tried in order.
+var syslogLocalAddrs = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogWriter implements LoggerInterface.
+// It writes to the local syslog daemon or to a remote one over udp, tcp
+// or tls. LogMsg.Level is used as the severity directly.
+type syslogWriter struct {
+	lock      sync.Mutex
+	conn      net.Conn
+	framer    *framer
+	formatter LogFormatter
+	facility  int
+	pid       string
+
+	Formatter string `json:"formatter"`
+	Net       string `json:"net"`
+	Addr      string `json:"addr"`
+	Level     int    `json:"level"`
+	RFC       string `json:"format"`
+	Facility  string `json:"facility"`
+	Hostname  string `json:"hostname"`
+	AppName   string `json:"appname"`
+	MsgID     string `json:"msgid"`
+	SDID      string `json:"sdid"`
+	Framing   string `json:"framing"`
+	TLSOptions
+}
+
+// NewSyslog creates a syslog writer returning as LoggerInterface.
+func NewSyslog() Logger {
+	s := &syslogWriter{
+		Level:    LevelDebug,
+		RFC:      SyslogRFC5424,
+		Facility: "user",
+		SDID:     "fields@32473",
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+	s.formatter = s
+	return s
+}
+
+// Init initializes the syslog writer with json config.
+// Without net it writes to the local daemon, otherwise net is udp, tcp
+// or tls, and tls accepts the settings of the conn adapter:
+//
+//	{
+//	"net":"tcp",
+//	"addr":"logs.bhojpur.net:514",
+//	"format":"rfc5424",
+//	"facility":"local0",
+//	"hostname":"web-1",
+//	"appname":"shop",
+//	"msgid":"orders",
+//	"level":6
+//	}
+//
+// Structured fields of a message become the structured data element
+// named by "sdid" in rfc5424. Stream connections use octet counting
+// unless "framing" says otherwise.
+func (s *syslogWriter) Init(config string) error {
+	if err := json.Unmarshal([]byte(config), s); err != nil {
+		return err
+	}
+	if len(s.Formatter) > 0 {
+		fmtr, ok := GetFormatter(s.Formatter)
+		if !ok {
+			return errors.New(fmt.Sprintf("the formatter with name: %s not found", s.Formatter))
+		}
+		s.formatter = fmtr
+	}
+	if s.RFC != SyslogRFC5424 && s.RFC != SyslogRFC3164 {
+		return errors.New(fmt.Sprintf("unknown syslog format: %s", s.RFC))
+	}
+	facility, ok := syslogFacilities[s.Facility]
+	if !ok {
+		return errors.New(fmt.Sprintf("unknown syslog facility: %s", s.Facility))
+	}
+	s.facility = facility
+	if s.Hostname == "" {
+		s.Hostname, _ = os.Hostname()
+	}
+	if s.AppName == "" {
+		s.AppName = filepath.Base(os.Args[0])
+	}
+
+	framing := s.Framing
+	network := s.Net
+	switch s.Net {
+	case "", "unix", "unixgram":
+		network = "unixgram"
+	case "tcp", "tcp4", "tcp6", "tls":
+		if framing == "" {
+			framing = FramingOctet
+		}
+	case "udp", "udp4", "udp6":
+	default:
+		return errors.New(fmt.Sprintf("unknown syslog network: %s", s.Net))
+	}
+	var err error
+	s.framer, err = newFramer(framing, network, 0, "")
+	return err
+}
+
+func (s *syslogWriter) SetFormatter(f LogFormatter) {
+	s.formatter = f
+}
+
+// Format returns the MSG part. The header carries level, time and caller.
+func (s *syslogWriter) Format(lm *LogMsg) string {
+	if lm.Prefix == "" {
+		return lm.Message()
+	}
+	return lm.Prefix + " " + lm.Message()
+}
+
+// WriteMsg writes message to syslog.
+// The connection is set up again once when writing fails.
+func (s *syslogWriter) WriteMsg(lm *LogMsg) error {
+	if lm.Level > s.Level {
+		return nil
+	}
+	msg := s.message(lm, s.formatter.Format(lm))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var err error
+	for i := 0; i < 2; i++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				continue
+			}
+		}
+		if err = s.write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *syslogWriter) write(msg string) error {
+	for _, frame := range s.framer.frames(msg) {
+		if _, err := s.conn.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogWriter) connect() error {
+	switch s.Net {
+	case "", "unix", "unixgram":
+		addrs := syslogLocalAddrs
+		if s.Addr != "" {
+			addrs = []string{s.Addr}
+		}
+		var err error
+		for _, addr := range addrs {
+			for _, network := range []string{"unixgram", "unix"} {
+				if s.Net != "" && s.Net != network {
+					continue
+				}
+				var conn net.Conn
+				if conn, err = net.Dial(network, addr); err == nil {
+					s.conn = conn
+					return nil
+				}
+			}
+		}
+		return err
+	case "tls":
+		cfg, err := s.ClientConfig(s.Addr)
+		if err != nil {
+			return err
+		}
+		conn, err := tls.Dial("tcp", s.Addr, cfg)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	default:
+		conn, err := net.Dial(s.Net, s.Addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	}
+}
+
+// message builds the syslog message around msg.
+func (s *syslogWriter) message(lm *LogMsg, msg string) string {
+	pri := s.facility*8 + lm.Level
+	if s.RFC == SyslogRFC3164 {
+		tag := syslogHeaderField(s.AppName, 32)
+		if s.Net == "" || strings.HasPrefix(s.Net, "unix") {
+			// the local daemon adds the hostname itself
+			return fmt.Sprintf("<%d>%s %s[%s]: %s", pri, lm.When.Format(time.Stamp), tag, s.pid, msg)
+		}
+		return fmt.Sprintf("<%d>%s %s %s[%s]: %s", pri, lm.When.Format(time.Stamp),
+			syslogHeaderField(s.Hostname, 255), tag, s.pid, msg)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s", pri,
+		lm.When.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.Hostname, 255),
+		syslogHeaderField(s.AppName, 48),
+		syslogHeaderField(s.pid, 128),
+		syslogHeaderField(s.MsgID, 32),
+		s.structuredData(lm.Fields),
+		msg)
+}
+
+// structuredData returns the RFC 5424 STRUCTURED-DATA for fields.
+func (s *syslogWriter) structuredData(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("[")
+	b.WriteString(syslogSDName(s.SDID))
+	for _, k := range keys {
+		b.WriteString(" ")
+		b.WriteString(syslogSDName(k))
+		b.WriteString(`="`)
+		b.WriteString(syslogSDEscaper.Replace(fmt.Sprint(fields[k])))
+		b.WriteString(`"`)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+var syslogSDEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField returns s as a header field of at most max printable
+// ASCII characters, or the nil value "-" when s is empty.
+func syslogHeaderField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	b := []byte(s)
+	if len(b) > max {
+		b = b[:max]
+	}
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// syslogSDName returns s as an SD-NAME, which excludes '=', ' ', ']' and '"'.
+func syslogSDName(s string) string {
+	b := []byte(syslogHeaderField(s, 32))
+	for i, c := range b {
+		if c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// Flush implementing method. empty.
+func (s *syslogWriter) Flush() {
+
+}
+
+// Destroy closes the connection to the syslog daemon.
+func (s *syslogWriter) Destroy() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func init() {
+	Register(AdapterSyslog, NewSyslog)
+}
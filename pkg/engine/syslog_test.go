@@ -0,0 +1,134 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogMessage(t *testing.T) {
+	s := NewSyslog().(*syslogWriter)
+	assert.Nil(t, s.Init(`{"net":"udp","addr":"127.0.0.1:514","facility":"local0","hostname":"web 1","appname":"shop","msgid":"orders"}`))
+	s.pid = "42"
+	lm := &LogMsg{
+		Level:  LevelError,
+		Msg:    "order %d failed",
+		Args:   []interface{}{7},
+		When:   time.Date(2020, 9, 19, 20, 12, 37, 9000, time.UTC),
+		Prefix: "api",
+		Fields: map[string]interface{}{"user": "bob", "path": `/a"]\`},
+	}
+	assert.Equal(t, `<131>1 2020-09-19T20:12:37.000009Z web_1 shop 42 orders [fields@32473 path="/a\"\]\\" user="bob"] api order 7 failed`,
+		s.message(lm, s.Format(lm)))
+
+	lm.Fields = nil
+	s.RFC = SyslogRFC3164
+	assert.Equal(t, "<131>Sep 19 20:12:37 web_1 shop[42]: api order 7 failed", s.message(lm, s.Format(lm)))
+	s.Net = ""
+	assert.Equal(t, "<131>Sep 19 20:12:37 shop[42]: api order 7 failed", s.message(lm, s.Format(lm)))
+}
+
+func TestSyslogInitErrors(t *testing.T) {
+	assert.NotNil(t, NewSyslog().Init(`{"facility":"local9"}`))
+	assert.NotNil(t, NewSyslog().Init(`{"format":"rfc1234"}`))
+	assert.NotNil(t, NewSyslog().Init(`{"net":"sctp"}`))
+}
+
+func TestSyslogLocal(t *testing.T) {
+	dir, err := os.MkdirTemp("", "syslog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "log")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	s := NewSyslog()
+	assert.Nil(t, s.Init(fmt.Sprintf(`{"addr":"%s","format":"rfc3164","appname":"shop","level":6}`, addr)))
+	defer s.Destroy()
+	assert.Nil(t, s.WriteMsg(&LogMsg{Level: LevelDebug, Msg: "filtered", When: time.Now()}))
+	assert.Nil(t, s.WriteMsg(&LogMsg{Level: LevelWarning, Msg: "disk low", When: time.Now()}))
+
+	buf := make([]byte, 1024)
+	ln.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := ln.ReadFrom(buf)
+	assert.Nil(t, err)
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<12>"), msg)
+	assert.True(t, strings.HasSuffix(msg, fmt.Sprintf(" shop[%d]: disk low\n", os.Getpid())), msg)
+}
+
+func TestSyslogTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	lines := make(chan string, 10)
+	go func() {
+		// the first connection is dropped at once
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			var n int
+			if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+				return
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			lines <- string(buf)
+		}
+	}()
+
+	s := NewSyslog()
+	assert.Nil(t, s.Init(fmt.Sprintf(`{"net":"tcp","addr":"%s","hostname":"h","appname":"a","msgid":"m"}`, ln.Addr())))
+	defer s.Destroy()
+	for i := 0; i < 50; i++ {
+		s.WriteMsg(&LogMsg{Level: LevelInfo, Msg: "hello\nworld", When: time.Now()})
+		select {
+		case line := <-lines:
+			assert.True(t, strings.HasPrefix(line, "<14>1 "), line)
+			assert.True(t, strings.HasSuffix(line, fmt.Sprintf(" h a %d m - hello\nworld", os.Getpid())), line)
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	t.Error("did not reconnect")
+}
@@ -0,0 +1,141 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPKI is a throwaway CA issuing server and client certificates.
+type testPKI struct {
+	dir    string
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	p := &testPKI{dir: t.TempDir()}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	p.caCert, err = x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	p.caKey = key
+	p.serial = 1
+	p.writePEM(t, "ca.pem", "CERTIFICATE", der)
+	return p
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+func (p *testPKI) writePEM(t *testing.T, name, typ string, der []byte) {
+	assert.Nil(t, ioutil.WriteFile(p.path(name), pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+}
+
+// issue writes <name>.pem and <name>-key.pem and returns the certificate.
+func (p *testPKI) issue(t *testing.T, name string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	p.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.DNSNames = []string{"localhost"}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.caCert, &key.PublicKey, p.caKey)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	p.writePEM(t, name+".pem", "CERTIFICATE", der)
+	p.writePEM(t, name+"-key.pem", "EC PRIVATE KEY", keyDer)
+	cert, err := tls.LoadX509KeyPair(p.path(name+".pem"), p.path(name+"-key.pem"))
+	assert.Nil(t, err)
+	return cert
+}
+
+// serverConfig returns a server config requiring client certificates of the CA.
+func (p *testPKI) serverConfig(t *testing.T) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.caCert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{p.issue(t, "server", true)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+func TestTLSOptions_ClientConfig(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issue(t, "client", false)
+
+	o := &TLSOptions{CA: pki.path("ca.pem"), Cert: pki.path("client.pem"), Key: pki.path("client-key.pem")}
+	cfg, err := o.ClientConfig("logs.bhojpur.net:6514")
+	assert.Nil(t, err)
+	assert.Equal(t, "logs.bhojpur.net", cfg.ServerName)
+	assert.Equal(t, 1, len(cfg.Certificates))
+	assert.NotNil(t, cfg.RootCAs)
+
+	o = &TLSOptions{ServerName: "other", InsecureSkipVerify: true}
+	cfg, err = o.ClientConfig("logs.bhojpur.net:6514")
+	assert.Nil(t, err)
+	assert.Equal(t, "other", cfg.ServerName)
+	assert.True(t, cfg.InsecureSkipVerify)
+
+	_, err = (&TLSOptions{Cert: pki.path("client.pem")}).ClientConfig("localhost:1")
+	assert.NotNil(t, err)
+	_, err = (&TLSOptions{CA: pki.path("missing.pem")}).ClientConfig("localhost:1")
+	assert.NotNil(t, err)
+}